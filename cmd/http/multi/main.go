@@ -8,13 +8,23 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/rkorkosz/go-hook/internal/discovery"
 	"github.com/rkorkosz/go-hook/internal/transport"
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+	"github.com/rkorkosz/go-hook/pkg/pubsub/wal"
 )
 
 func main() {
 	addr := flag.String("bind", ":8000", "address to bind on")
+	wsAddr := flag.String("ws-bind", "", "address of the WebSocket transport, disabled when empty")
+	dataDir := flag.String("data", "", "directory of the durable message log, disabled when empty")
+	retentionSize := flag.Int64("retention-size", 1024, "MiB of the durable message log kept, zero means no limit")
+	retentionAge := flag.Duration("retention-age", 7*24*time.Hour, "age after which durable messages are removed, zero means no limit")
+	history := flag.Int("history", 1000, "messages kept per topic for reconnecting subscribers without -data")
+	deadLetters := flag.Bool("dead-letters", false, "publish dropped messages to $dlq.<topic>")
+	systemEvents := flag.Bool("system-events", false, "publish subscription lifecycle events to $sys.<event>")
 	flag.Parse()
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -25,13 +35,44 @@ func main() {
 	})
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
+	ps := pubsub.New(func(ps *pubsub.PubSub) {
+		ps.HistorySize = *history
+		ps.DeadLetters = *deadLetters
+		ps.SystemEvents = *systemEvents
+	})
+	if *dataDir != "" {
+		storage, err := wal.Open(*dataDir, func(l *wal.Log) {
+			l.MaxSize = *retentionSize << 20
+			l.MaxAge = *retentionAge
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		ps.Storage = storage
+	}
 	t := transport.NewHTTP(func(ht *transport.HTTP) {
+		ht.PubSub = ps
 		ht.Server.Addr = *addr
 		ht.Servers = servers
 		ht.Server.BaseContext = func(net.Listener) context.Context {
 			return ctx
 		}
 	})
+	if *wsAddr != "" {
+		ws := transport.NewWebSocket(func(ws *transport.WebSocket) {
+			ws.PubSub = ps
+			ws.Relay = t
+			ws.Server.Addr = *wsAddr
+			ws.Server.BaseContext = func(net.Listener) context.Context {
+				return ctx
+			}
+		})
+		go func(ctx context.Context) {
+			if err := ws.Run(ctx); err != nil {
+				log.Fatal(err)
+			}
+		}(ctx)
+	}
 	go func(ctx context.Context) {
 		err := servers.Run(ctx)
 		if err != nil {
@@ -39,6 +80,9 @@ func main() {
 		}
 	}(ctx)
 	err = t.Run(ctx)
+	if err := ps.Close(); err != nil {
+		log.Println(err)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
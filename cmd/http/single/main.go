@@ -11,7 +11,9 @@ import (
 )
 
 func main() {
-	ps := pubsub.New(100)
+	ps := pubsub.New(func(ps *pubsub.PubSub) {
+		ps.HistorySize = 1000
+	})
 	ht := transport.NewHTTP(func(ht *transport.HTTP) {
 		ht.PubSub = ps
 	})
@@ -5,12 +5,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rkorkosz/go-hook/pkg/pubsub"
@@ -18,10 +23,31 @@ import (
 
 // HTTP represents transport over HTTP protocol
 type HTTP struct {
-	Server       *http.Server
-	Servers      Servers
-	PubSub       PubSub
-	Log          *log.Logger
+	Server  *http.Server
+	Servers Servers
+	PubSub  PubSub
+	Log     *log.Logger
+	// Subscription configures queues of subscribers connected over HTTP.
+	Subscription pubsub.SubscribeOptions
+	// MaxBodySize caps the size of published request bodies.
+	MaxBodySize int64
+	// PublishInterceptors run on messages published over HTTP before
+	// they reach PubSub. Delivery interceptors are set in Subscription.
+	PublishInterceptors []pubsub.PublishInterceptor
+	// RequestTimeout caps how long a request published with the reply
+	// query parameter waits for its reply.
+	RequestTimeout time.Duration
+	// SSERetry is the reconnection delay sent to Server-Sent Events
+	// subscribers, zero leaves it to the client.
+	SSERetry time.Duration
+	// PollTimeout caps how long a long poll waits for messages.
+	PollTimeout time.Duration
+	// PollLimit caps the number of messages returned to a long poll.
+	PollLimit int
+	// MaxStreamTopics caps the number of topics subscribed in one
+	// stream, zero means no limit.
+	MaxStreamTopics int
+
 	remoteClient *http.Client
 	fanoutLimit  chan struct{} // limits concurrent cross-server publishes
 }
@@ -33,8 +59,20 @@ func NewHTTP(opts ...func(ht *HTTP)) *HTTP {
 			Addr:              ":8000",
 			ReadHeaderTimeout: 10 * time.Second,
 		},
-		PubSub: pubsub.New(100),
-		Log:    log.New(os.Stdout, "[HTTP] ", log.LstdFlags),
+		PubSub: pubsub.New(func(ps *pubsub.PubSub) {
+			ps.HistorySize = 1000
+		}),
+		Log: log.New(os.Stdout, "[HTTP] ", log.LstdFlags),
+		Subscription: pubsub.SubscribeOptions{
+			BufferSize: 64,
+			Overflow:   pubsub.DropOldest,
+		},
+		MaxBodySize:     1 << 20,
+		RequestTimeout:  30 * time.Second,
+		SSERetry:        3 * time.Second,
+		PollTimeout:     30 * time.Second,
+		PollLimit:       100,
+		MaxStreamTopics: 50,
 		remoteClient: &http.Client{
 			Timeout: 5 * time.Second,
 		},
@@ -81,27 +119,47 @@ func (ht *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (ht *HTTP) publishToServer(server, source, topic string, data []byte) {
+// publishToServer relays msg to another server without waiting for
+// its delivery report
+func (ht *HTTP) publishToServer(server string, msg pubsub.Data) {
 	if ht.fanoutLimit != nil {
 		defer func() { <-ht.fanoutLimit }()
 	}
 
-	uri := fmt.Sprintf("%s/%s/%s", server, topic, source)
+	if _, err := ht.relay(server, msg, false); err != nil {
+		ht.Log.Println(err)
+	}
+}
+
+// relay publishes msg on server. With sync it asks the server for
+// a synchronous publish and returns its delivery report.
+func (ht *HTTP) relay(server string, msg pubsub.Data, sync bool) (pubsub.Report, error) {
+	body, err := msg.Payload()
+	if err != nil {
+		return pubsub.Report{}, err
+	}
+	uri := fmt.Sprintf("%s/%s/%s", server, url.PathEscape(msg.Topic), url.PathEscape(msg.Source))
+	if sync {
+		uri += "?sync=true"
+	}
 	// #nosec G704 -- uri comes from internal server list, not user input
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(data))
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(body))
 	if err != nil {
-		ht.Log.Println(err)
-		return
+		return pubsub.Report{}, err
 	}
 
 	hostname, err := os.Hostname()
 	if err != nil {
-		ht.Log.Println(err)
-		return
+		return pubsub.Report{}, err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Add("Content-Type", contentType)
 	req.Header.Add("Origin", hostname)
+	setMessageHeaders(req.Header, msg)
 	client := ht.remoteClient
 	if client == nil {
 		client = http.DefaultClient
@@ -109,8 +167,7 @@ func (ht *HTTP) publishToServer(server, source, topic string, data []byte) {
 	// #nosec G704 -- req comes from internal server list, not user input
 	resp, err := client.Do(req)
 	if err != nil {
-		ht.Log.Println(err)
-		return
+		return pubsub.Report{}, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -119,12 +176,28 @@ func (ht *HTTP) publishToServer(server, source, topic string, data []byte) {
 	}()
 
 	ht.Log.Printf("Publish status code: %d", resp.StatusCode)
+	if !sync {
+		return pubsub.Report{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return pubsub.Report{}, fmt.Errorf("publish to %s: %s", server, resp.Status)
+	}
+	var report pubsub.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return pubsub.Report{}, err
+	}
+	return report, nil
 }
 
 func (ht *HTTP) subscribe(w http.ResponseWriter, r *http.Request) {
-	topic, err := subscribePath(r.URL.Path)
+	query := r.URL.Query()
+	topics, err := subscribeTopics(r.URL.EscapedPath(), query["topic"])
 	if err != nil {
-		http.Error(w, "please provide topic in path (/topic)", http.StatusBadRequest)
+		http.Error(w, "please provide topics in path (/topic or /topic1,topic2) or topic query parameters", http.StatusBadRequest)
+		return
+	}
+	if ht.MaxStreamTopics > 0 && len(topics) > ht.MaxStreamTopics {
+		http.Error(w, fmt.Sprintf("at most %d topics per stream", ht.MaxStreamTopics), http.StatusBadRequest)
 		return
 	}
 	source := r.RemoteAddr
@@ -132,85 +205,511 @@ func (ht *HTTP) subscribe(w http.ResponseWriter, r *http.Request) {
 		source = "local"
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
+	if poll := query.Get("poll"); poll != "" {
+		if ok, err := strconv.ParseBool(poll); err != nil || !ok {
+			http.Error(w, fmt.Sprintf("invalid poll flag %q", poll), http.StatusBadRequest)
+			return
+		}
+		if len(topics) != 1 {
+			http.Error(w, "long polling needs a single topic", http.StatusBadRequest)
+			return
+		}
+		ht.poll(w, r, topics[0])
+		return
+	}
+	events := query.Get("event")
+	if events != "" && events != eventsByType && events != eventsByTopic {
+		http.Error(w, "event must be type or topic", http.StatusBadRequest)
+		return
+	}
+	mediaType := streamType(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", mediaType)
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
 	rc := http.NewResponseController(w)
-	ht.Log.Printf("subscribing to %s", topic)
+	ht.Log.Printf("subscribing to %s", strings.Join(topics, ", "))
 
-	ch, err := ht.PubSub.Subscribe(source, topic)
-	if err != nil {
-		ht.Log.Println(err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+	opts := func(o *pubsub.SubscribeOptions) {
+		*o = ht.Subscription
+		o.Group = query.Get("group")
+		o.Filter = query.Get("filter")
+		o.Transport = "http"
 	}
-	if err := rc.Flush(); err != nil {
+	// every subscription of the stream is removed when it ends
+	var subscribed []string
+	defer func() {
+		for _, topic := range subscribed {
+			ht.PubSub.Unsubscribe(source, topic)
+		}
+	}()
+	replayer, cursors, resets := ht.resumable(lastEventIDs(r, topics), topics)
+	chans := make([]pubsub.DataChannel, 0, len(topics))
+	for _, topic := range topics {
+		var ch pubsub.DataChannel
+		if seq, ok := cursors[topic]; ok {
+			ch, err = replayer.SubscribeFrom(source, topic, seq, opts)
+		} else {
+			ch, err = ht.PubSub.Subscribe(source, topic, opts)
+		}
+		if err != nil {
+			ht.error(w, err)
+			return
+		}
+		subscribed = append(subscribed, topic)
+		chans = append(chans, ch)
+	}
+
+	// both encoders drop messages overlapping subscriptions deliver again
+	last := make(map[string]uint64, len(topics))
+	for _, topic := range topics {
+		if !pubsub.IsPattern(topic) {
+			last[topic] = cursors[topic]
+		}
+	}
+	resumed := make(map[string]bool, len(cursors))
+	for topic := range cursors {
+		resumed[topic] = true
+	}
+	encode := (&ndjsonEncoder{enc: json.NewEncoder(w), last: last, resumed: resumed}).Encode
+	if mediaType == mediaEventStream {
+		sse := &sseEncoder{
+			w:       w,
+			byTopic: events == eventsByTopic,
+			// filtered and shared streams skip messages on purpose
+			gaps:      query.Get("filter") == "" && query.Get("group") == "",
+			composite: len(topics) > 1,
+			last:      last,
+			resumed:   resumed,
+		}
+		encode = sse.Encode
+		if ht.SSERetry > 0 {
+			err = sse.retry(ht.SSERetry)
+		}
+		for _, topic := range resets {
+			if err == nil {
+				err = sse.reset(topic)
+			}
+		}
+	}
+	if err == nil {
+		err = rc.Flush()
+	}
+	if err != nil {
 		ht.Log.Println(err)
 		return
 	}
-	enc := json.NewEncoder(w)
+
+	done := make(chan struct{})
+	defer close(done)
+	messages, ended := fanIn(chans, done)
 	for {
 		select {
-		case m, ok := <-ch:
-			if !ok {
-				return
-			}
-			if err := enc.Encode(m); err != nil {
+		case m := <-messages:
+			if err := encode(m); err != nil {
 				ht.Log.Println(err)
+				deadLetter(ht.PubSub, source, m, err)
 				return
 			}
 			if err := rc.Flush(); err != nil {
 				ht.Log.Println(err)
+				deadLetter(ht.PubSub, source, m, err)
 				return
 			}
+		case <-ended:
+			return
 		case <-r.Context().Done():
 			ht.Log.Println("Unsubscribe")
-			ht.PubSub.Unsubscribe(source, topic)
 			return
 		}
 	}
 }
 
+// fanIn multiplexes the subscriptions of a stream into one channel
+// until done is closed. ended receives when any subscription is closed,
+// e.g. a slow subscriber disconnected.
+func fanIn(chans []pubsub.DataChannel, done <-chan struct{}) (messages <-chan pubsub.Data, ended <-chan struct{}) {
+	out := make(chan pubsub.Data)
+	closed := make(chan struct{}, len(chans))
+	for _, ch := range chans {
+		go func() {
+			for m := range ch {
+				select {
+				case out <- m:
+				case <-done:
+					return
+				}
+			}
+			closed <- struct{}{}
+		}()
+	}
+	return out, closed
+}
+
 func (ht *HTTP) publish(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if err := r.Body.Close(); err != nil {
 			ht.Log.Printf("Error closing request body: %v", err)
 		}
 	}()
-	data, err := io.ReadAll(r.Body)
+	body := r.Body
+	if ht.MaxBodySize > 0 {
+		body = http.MaxBytesReader(w, r.Body, ht.MaxBodySize)
+	}
+	data, err := io.ReadAll(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		ht.error(w, pubsub.ErrMessageTooLarge)
+		return
+	}
 	if err != nil {
 		ht.Log.Println(err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	topic, source, err := publishPath(r.URL.Path)
+	topic, source, err := publishPath(r.URL.EscapedPath())
 	if err != nil {
 		http.Error(w, "please provide topic and id in path (/topic/id)", http.StatusBadRequest)
 		return
 	}
+	if err := pubsub.ValidateClientTopic(topic); errors.Is(err, pubsub.ErrReservedTopic) {
+		ht.error(w, fmt.Errorf("%w: %q", err, topic))
+		return
+	} else if err != nil {
+		http.Error(w, "wildcards are not allowed in publish topic", http.StatusBadRequest)
+		return
+	}
 	ht.Log.Printf("publishing message to: %s", topic)
 
+	msg, err := messageFromRequest(r, topic, source, data)
+	if errors.Is(err, pubsub.ErrInvalidPayload) {
+		http.Error(w, "request body does not match its content type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := pubsub.Intercept(&msg, ht.PublishInterceptors); err != nil {
+		ht.error(w, err)
+		return
+	}
+	query := r.URL.Query()
+	mode := query.Get("sync")
+	if mode != "" && mode != syncLocal && mode != syncCluster {
+		http.Error(w, "sync must be true or cluster", http.StatusBadRequest)
+		return
+	}
+	if reply := query.Get("reply"); reply != "" {
+		timeout, err := ht.requestTimeout(reply, query.Get("timeout"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ht.request(w, r, msg, timeout)
+		return
+	}
 	origin := r.Header.Get("Origin")
-	ht.PubSub.Publish(source, topic, data)
-	if origin == "" && ht.Servers != nil {
-		for srv := range ht.Servers.Iter() {
+	report, err := ht.PubSub.PublishData(msg)
+	if err != nil {
+		ht.error(w, err)
+		return
+	}
+	msg = report.Message
+
+	var servers map[string]serverReport
+	if origin == "" && ht.Servers != nil && !report.Duplicate {
+		if mode == syncCluster {
+			servers = ht.relayAll(msg)
+		} else {
+			ht.fanout(msg)
+		}
+	}
+	if mode == "" {
+		w.WriteHeader(202)
+		return
+	}
+
+	resp := publishResponse{ID: msg.ID, Seq: msg.Seq, Report: report, Servers: servers}
+	for _, server := range servers {
+		resp.Add(server.Report)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		ht.Log.Println(err)
+	}
+}
+
+// requestTimeout parses the reply and timeout query parameters of
+// a request, the timeout is capped by RequestTimeout
+func (ht *HTTP) requestTimeout(reply, value string) (time.Duration, error) {
+	if ok, err := strconv.ParseBool(reply); err != nil || !ok {
+		return 0, fmt.Errorf("invalid reply flag %q", reply)
+	}
+	timeout := ht.RequestTimeout
+	if value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid timeout %q", value)
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("requests are disabled")
+	}
+	return timeout, nil
+}
+
+// request publishes msg to all servers and replies with the payload and
+// metadata of the first reply
+func (ht *HTTP) request(w http.ResponseWriter, r *http.Request, msg pubsub.Data, timeout time.Duration) {
+	requester, ok := ht.PubSub.(Requester)
+	if !ok {
+		http.Error(w, "requests are not supported", http.StatusNotImplemented)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	reply, err := requester.Request(ctx, msg, func(o *pubsub.RequestOptions) {
+		o.Transport = "http"
+		o.Publish = func(msg pubsub.Data) (pubsub.Report, error) {
+			report, err := ht.PubSub.PublishData(msg)
+			if err != nil || ht.Servers == nil || report.Duplicate {
+				return report, err
+			}
+			// reports of other servers tell whether anyone got the request
+			for _, server := range ht.relayAll(report.Message) {
+				report.Add(server.Report)
+			}
+			return report, nil
+		}
+	})
+	if err != nil {
+		ht.error(w, err)
+		return
+	}
+
+	body, err := reply.Payload()
+	if err != nil {
+		ht.error(w, err)
+		return
+	}
+	contentType := reply.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	setMessageHeaders(w.Header(), reply)
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(body); err != nil {
+		ht.Log.Println(err)
+	}
+}
+
+// Relay implements Relayer interface, it relays msg to every server in
+// the background like messages published over HTTP
+func (ht *HTTP) Relay(msg pubsub.Data) {
+	if ht.Servers != nil {
+		ht.fanout(msg)
+	}
+}
+
+// fanout relays msg to every server in the background
+func (ht *HTTP) fanout(msg pubsub.Data) {
+	for srv := range ht.Servers.Iter() {
+		if ht.fanoutLimit != nil {
+			ht.fanoutLimit <- struct{}{} // wait for a slot
+		}
+		go ht.publishToServer(srv, msg)
+	}
+}
+
+// Values of the sync query parameter of publish requests. Local waits
+// for delivery to local subscribers, cluster also waits for reports of
+// all other servers.
+const (
+	syncLocal   = "true"
+	syncCluster = "cluster"
+)
+
+// publishResponse is the reply to a synchronous publish. Counters of
+// the embedded report sum local and remote deliveries.
+type publishResponse struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq,omitempty"`
+	pubsub.Report
+	Servers map[string]serverReport `json:"servers,omitempty"`
+}
+
+// serverReport is the delivery report of one remote server
+type serverReport struct {
+	pubsub.Report
+	Error string `json:"error,omitempty"`
+}
+
+// relayAll relays msg to every server and waits for their reports
+func (ht *HTTP) relayAll(msg pubsub.Data) map[string]serverReport {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		reports = make(map[string]serverReport)
+	)
+	for srv := range ht.Servers.Iter() {
+		if ht.fanoutLimit != nil {
+			ht.fanoutLimit <- struct{}{} // wait for a slot
+		}
+		wg.Go(func() {
 			if ht.fanoutLimit != nil {
-				ht.fanoutLimit <- struct{}{} // wait for a slot
+				defer func() { <-ht.fanoutLimit }()
+			}
+			var result serverReport
+			report, err := ht.relay(srv, msg, true)
+			if err != nil {
+				ht.Log.Println(err)
+				result.Error = err.Error()
 			}
-			go ht.publishToServer(srv, source, topic, data)
+			result.Report = report
+
+			mu.Lock()
+			reports[srv] = result
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return reports
+}
+
+// error replies with the status code matching a pubsub error
+func (ht *HTTP) error(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		ht.Log.Println(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, pubsub.ErrReservedTopic), errors.Is(err, pubsub.ErrRejected):
+		return http.StatusForbidden
+	case errors.Is(err, pubsub.ErrInvalidTopic), errors.Is(err, pubsub.ErrInvalidPayload),
+		errors.Is(err, pubsub.ErrInvalidFilter):
+		return http.StatusBadRequest
+	case errors.Is(err, pubsub.ErrMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, pubsub.ErrTooManySubscribers):
+		return http.StatusTooManyRequests
+	case errors.Is(err, pubsub.ErrTooManyTopics), errors.Is(err, pubsub.ErrTooManySubscriptions),
+		errors.Is(err, pubsub.ErrTooManyRetained), errors.Is(err, pubsub.ErrTooManyScheduled),
+		errors.Is(err, pubsub.ErrNoResponders), errors.Is(err, pubsub.ErrTooManyRequests):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// lastEventIDs returns the sequence numbers of the last messages
+// a reconnecting subscriber received by topic, from the Last-Event-ID
+// header or the lastEventId query parameter. Streams of one topic use
+// the sequence number as event ID, streams of several topics a query
+// string of topic=sequence pairs.
+func lastEventIDs(r *http.Request, topics []string) map[string]uint64 {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("lastEventId")
+	}
+	if id == "" {
+		return nil
+	}
+
+	cursors := make(map[string]uint64)
+	// IDs of messages published without history are not sequences
+	if len(topics) == 1 {
+		if seq, err := strconv.ParseUint(id, 10, 64); err == nil {
+			cursors[topics[0]] = seq
 		}
+		return cursors
+	}
+	values, err := url.ParseQuery(id)
+	if err != nil {
+		return nil
 	}
-	w.WriteHeader(202)
+	for _, topic := range topics {
+		if seq, err := strconv.ParseUint(values.Get(topic), 10, 64); err == nil {
+			cursors[topic] = seq
+		}
+	}
+	return cursors
 }
 
-func subscribePath(path string) (string, error) {
-	parts := pathParts(path)
-	if len(parts) != 1 {
-		return "", fmt.Errorf("invalid subscribe path")
+// resumable returns the Replayer and the cursors of topics a stream
+// resumes from history, and the topics whose cursor is ahead of their
+// last sequence number. Such a cursor was issued before a restart or by
+// another node, the stream then replays all history of the topic instead
+// of skipping messages until the sequence catches up.
+func (ht *HTTP) resumable(cursors map[string]uint64, topics []string) (Replayer, map[string]uint64, []string) {
+	replayer, ok := ht.PubSub.(Replayer)
+	var resets []string
+	for _, topic := range topics {
+		seq, resumed := cursors[topic]
+		if !resumed {
+			continue
+		}
+		var last uint64
+		history := false
+		if ok && !pubsub.IsPattern(topic) {
+			last, history = replayer.LastSeq(topic)
+		}
+		switch {
+		case !history:
+			delete(cursors, topic)
+		case seq > last:
+			cursors[topic] = 0
+			resets = append(resets, topic)
+		}
 	}
-	return parts[0], nil
+	return replayer, cursors, resets
+}
+
+// subscribeTopics returns the topics of a subscribe request, given as
+// a comma separated list in the path and as topic query parameters.
+// A comma within a topic of the path is escaped as %2C.
+func subscribeTopics(path string, params []string) ([]string, error) {
+	var topics []string
+	if path = strings.Trim(path, "/"); path != "" {
+		if strings.Contains(path, "/") {
+			return nil, fmt.Errorf("invalid subscribe path")
+		}
+		for part := range strings.SplitSeq(path, ",") {
+			topic, err := url.PathUnescape(part)
+			if err != nil || topic == "" {
+				return nil, fmt.Errorf("invalid subscribe path")
+			}
+			topics = append(topics, topic)
+		}
+	}
+	for _, topic := range params {
+		if topic == "" {
+			return nil, fmt.Errorf("empty topic parameter")
+		}
+		topics = append(topics, topic)
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("missing topic")
+	}
+
+	seen := make(map[string]bool, len(topics))
+	return slices.DeleteFunc(topics, func(topic string) bool {
+		dup := seen[topic]
+		seen[topic] = true
+		return dup
+	}), nil
 }
 
 func publishPath(path string) (topic, source string, err error) {
@@ -221,6 +720,8 @@ func publishPath(path string) (topic, source string, err error) {
 	return parts[0], parts[1], nil
 }
 
+// pathParts splits an escaped path into unescaped segments, so topics
+// containing a slash are sent as %2F
 func pathParts(path string) []string {
 	path = strings.Trim(path, "/")
 	if path == "" {
@@ -228,10 +729,12 @@ func pathParts(path string) []string {
 	}
 
 	parts := strings.Split(path, "/")
-	for _, part := range parts {
-		if part == "" {
+	for i, part := range parts {
+		part, err := url.PathUnescape(part)
+		if err != nil || part == "" {
 			return nil
 		}
+		parts[i] = part
 	}
 	return parts
 }
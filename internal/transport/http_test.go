@@ -1,11 +1,22 @@
 package transport
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
 )
 
 func TestPublishToServer(t *testing.T) {
@@ -32,6 +43,9 @@ func TestPublishToServer(t *testing.T) {
 		if got := r.Header.Get("Origin"); got == "" {
 			t.Error("expected origin header")
 		}
+		if got := r.Header.Get(headerMessageID); got != "id" {
+			t.Errorf("want message id header id, got %s", got)
+		}
 		data, err := io.ReadAll(r.Body)
 		if err != nil {
 			t.Error(err)
@@ -44,7 +58,7 @@ func TestPublishToServer(t *testing.T) {
 	defer server.Close()
 
 	ht := &HTTP{Log: log.New(io.Discard, "", 0)}
-	ht.publishToServer(server.URL, "source", "topic", []byte(body))
+	ht.publishToServer(server.URL, pubsub.Data{Source: "source", Topic: "topic", Data: []byte(body), ID: "id"})
 
 	select {
 	case <-requestSeen:
@@ -57,29 +71,37 @@ func TestPublishToServerInvalidURLDoesNotPanic(t *testing.T) {
 	t.Parallel()
 
 	ht := &HTTP{Log: log.New(io.Discard, "", 0)}
-	ht.publishToServer("://bad-url", "source", "topic", []byte(`{"hello":"world"}`))
+	ht.publishToServer("://bad-url", pubsub.Data{Source: "source", Topic: "topic", Data: []byte(`{"hello":"world"}`)})
 }
 
-func TestSubscribePath(t *testing.T) {
+func TestSubscribeTopics(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
 		name    string
 		path    string
-		want    string
+		params  []string
+		want    []string
 		wantErr bool
 	}{
-		{name: "topic", path: "/topic", want: "topic"},
-		{name: "topic with trailing slash", path: "/topic/", want: "topic"},
+		{name: "topic", path: "/topic", want: []string{"topic"}},
+		{name: "topic with trailing slash", path: "/topic/", want: []string{"topic"}},
+		{name: "escaped slash", path: "/%24reply%2Fid", want: []string{"$reply/id"}},
+		{name: "comma list", path: "/orders.*,payments", want: []string{"orders.*", "payments"}},
+		{name: "escaped comma", path: "/a%2Cb,c", want: []string{"a,b", "c"}},
+		{name: "query", path: "/", params: []string{"a,b", "c"}, want: []string{"a,b", "c"}},
+		{name: "path and query", path: "/a", params: []string{"b", "a"}, want: []string{"a", "b"}},
 		{name: "empty", path: "/", wantErr: true},
 		{name: "missing", path: "", wantErr: true},
 		{name: "too many parts", path: "/topic/source", wantErr: true},
 		{name: "empty part", path: "/topic//source", wantErr: true},
+		{name: "empty list item", path: "/a,,b", wantErr: true},
+		{name: "empty param", path: "/a", params: []string{""}, wantErr: true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			topic, err := subscribePath(tt.path)
+			topics, err := subscribeTopics(tt.path, tt.params)
 			if tt.wantErr {
 				if err == nil {
 					t.Fatal("expected error")
@@ -89,8 +111,8 @@ func TestSubscribePath(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if topic != tt.want {
-				t.Fatalf("want %q, got %q", tt.want, topic)
+			if !slices.Equal(topics, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, topics)
 			}
 		})
 	}
@@ -108,6 +130,8 @@ func TestPublishPath(t *testing.T) {
 	}{
 		{name: "topic and source", path: "/topic/source", wantTopic: "topic", wantSource: "source"},
 		{name: "trailing slash", path: "/topic/source/", wantTopic: "topic", wantSource: "source"},
+		{name: "escaped slash", path: "/a%2Fb/source", wantTopic: "a/b", wantSource: "source"},
+		{name: "invalid escape", path: "/a%zz/source", wantErr: true},
 		{name: "empty", path: "/", wantErr: true},
 		{name: "missing source", path: "/topic", wantErr: true},
 		{name: "too many parts", path: "/topic/source/extra", wantErr: true},
@@ -132,3 +156,616 @@ func TestPublishPath(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPLimitStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		ps     func(*pubsub.PubSub)
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{
+			name:   "too many topics",
+			ps:     func(ps *pubsub.PubSub) { ps.MaxTopics = 0 },
+			method: http.MethodGet,
+			path:   "/topic",
+			want:   http.StatusServiceUnavailable,
+		},
+		{
+			name:   "too many subscribers",
+			ps:     func(ps *pubsub.PubSub) { ps.MaxSubscribers = 0 },
+			method: http.MethodGet,
+			path:   "/topic",
+			want:   http.StatusTooManyRequests,
+		},
+		{
+			name:   "invalid pattern",
+			ps:     func(ps *pubsub.PubSub) {},
+			method: http.MethodGet,
+			path:   "/orders.>.created",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid filter",
+			ps:     func(ps *pubsub.PubSub) {},
+			method: http.MethodGet,
+			path:   "/topic?filter=total%3E1",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "message too large",
+			ps:     func(ps *pubsub.PubSub) { ps.MaxMessageSize = 2 },
+			method: http.MethodPost,
+			path:   "/topic/source",
+			body:   `{"a":1}`,
+			want:   http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ht := NewHTTP(func(ht *HTTP) {
+				ht.PubSub = pubsub.New(tt.ps)
+				ht.Log = log.New(io.Discard, "", 0)
+			})
+			w := httptest.NewRecorder()
+			ht.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("want status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestHTTPRejectsOversizedBody(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.MaxBodySize = 4
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topic/source", strings.NewReader(`{"a":1}`)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+// subscribeHTTP opens a subscriber stream of the accepted media type.
+// Response headers are flushed once the subscription is registered, so
+// messages published afterwards are delivered to the stream.
+func subscribeHTTP(t *testing.T, url, topic, accept string, header ...string) *bufio.Reader {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url+"/"+topic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", accept)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req) // #nosec G107 -- test server URL
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Logf("Error closing response body: %v", err)
+		}
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != accept {
+		t.Fatalf("want %s stream, got %s", accept, got)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestHTTPPublishesNonJSONPayload(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+
+	stream := subscribeHTTP(t, server.URL, "topic", mediaNDJSON)
+
+	resp, err := http.Post(server.URL+"/topic/source", "text/plain", strings.NewReader("hello\nworld"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("want status 202, got %d", resp.StatusCode)
+	}
+
+	line, err := stream.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got pubsub.Data
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatal(err)
+	}
+	body, err := got.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ContentType != "text/plain" || string(body) != "hello\nworld" {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+}
+
+func TestHTTPRejectsInvalidJSON(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/topic/source", strings.NewReader(`{"a":`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want status 400, got %d", w.Code)
+	}
+}
+
+func TestHTTPPublishInterceptorRejects(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.PublishInterceptors = []pubsub.PublishInterceptor{
+			func(msg *pubsub.Data) error {
+				if msg.Source != "trusted" {
+					return errors.New("untrusted source")
+				}
+				return nil
+			},
+		}
+	})
+	for source, want := range map[string]int{"trusted": http.StatusAccepted, "other": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topic/"+source, strings.NewReader(`{"a":1}`)))
+		if w.Code != want {
+			t.Errorf("%s: want status %d, got %d", source, want, w.Code)
+		}
+	}
+}
+
+func TestHTTPPublishReservedTopic(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	for topic, want := range map[string]int{
+		pubsub.DeadLetterPrefix + "orders": http.StatusForbidden,
+		pubsub.SystemPrefix + "subscribed": http.StatusForbidden,
+		pubsub.ReplyTopic():                http.StatusAccepted,
+	} {
+		w := httptest.NewRecorder()
+		ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+topic+"/source", strings.NewReader(`{"a":1}`)))
+		if w.Code != want {
+			t.Errorf("%s: want status %d, got %d", topic, want, w.Code)
+		}
+	}
+}
+
+type staticServers []string
+
+func (s staticServers) Iter() chan string {
+	ch := make(chan string, len(s))
+	for _, server := range s {
+		ch <- server
+	}
+	close(ch)
+	return ch
+}
+
+func TestHTTPSyncPublish(t *testing.T) {
+	t.Parallel()
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sync") != "true" {
+			t.Errorf("want synchronous relay, got %s", r.URL)
+		}
+		if _, err := w.Write([]byte(`{"matched":2,"delivered":1,"dropped":1}`)); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(remote.Close)
+
+	ps := pubsub.New()
+	if _, err := ps.Subscribe("id", "topic", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 1
+		o.Overflow = pubsub.DropNewest
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Servers = staticServers{remote.URL, "http://127.0.0.1:0"}
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topic/source?sync=cluster", strings.NewReader(`{"a":1}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", w.Code)
+	}
+	var got publishResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == "" || got.Matched != 3 || got.Delivered != 2 || got.Dropped != 1 {
+		t.Fatalf("unexpected report: %s", w.Body)
+	}
+	if len(got.Servers) != 2 || got.Servers[remote.URL].Delivered != 1 || got.Servers["http://127.0.0.1:0"].Error == "" {
+		t.Fatalf("unexpected server reports: %+v", got.Servers)
+	}
+
+	w = httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topic/source?sync=later", strings.NewReader(`{"a":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want status 400, got %d", w.Code)
+	}
+}
+
+func TestHTTPIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ch, err := ps.Subscribe("id", "topic", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = pubsub.DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+
+	var got publishResponse
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/topic/source?sync=true", strings.NewReader(`{"a":1}`))
+		r.Header.Set(headerIdempotencyKey, "evt-1")
+		w := httptest.NewRecorder()
+		ht.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("want status 200, got %d", w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !got.Duplicate || got.ID != "evt-1" || len(ch) != 1 {
+		t.Fatalf("want retry acknowledged as duplicate, got %+v with %d queued", got, len(ch))
+	}
+}
+
+func TestHTTPRequestReply(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ch, err := ps.Subscribe("responder", "rpc", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range ch {
+			reply := pubsub.Data{Source: "responder", Topic: msg.ReplyTo, Headers: map[string]string{"Status": "ok"}}
+			if err := reply.SetPayload("text/plain", []byte("pong")); err != nil {
+				t.Error(err)
+			}
+			if _, err := ps.PublishData(reply); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	if _, err := ps.Subscribe("silent", "silent", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 1
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc/client?reply=true", strings.NewReader(`"ping"`)))
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Fatalf("want reply pong, got %d %q", w.Code, w.Body)
+	}
+	if w.Header().Get("Content-Type") != "text/plain" || w.Header().Get(headerPrefix+"Status") != "ok" {
+		t.Fatalf("unexpected reply headers: %v", w.Header())
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/nobody/client?reply=true", http.StatusServiceUnavailable},
+		{"/silent/client?reply=true&timeout=20ms", http.StatusGatewayTimeout},
+		{"/rpc/client?reply=true&timeout=soon", http.StatusBadRequest},
+		{"/rpc/client?reply=maybe", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`"ping"`)))
+		if w.Code != tt.want {
+			t.Errorf("%s: want status %d, got %d", tt.path, tt.want, w.Code)
+		}
+	}
+}
+
+func TestHTTPRequestReplyOverHTTP(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	ch, err := ps.Subscribe("responder", "rpc", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range ch {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/"+msg.ReplyTo+"/responder", strings.NewReader("pong"))
+			r.Header.Set("Content-Type", "text/plain")
+			ht.ServeHTTP(w, r)
+			if w.Code != http.StatusAccepted {
+				t.Errorf("want reply accepted, got %d %q", w.Code, w.Body)
+			}
+		}
+	}()
+
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc/client?reply=true", strings.NewReader(`"ping"`)))
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Fatalf("want reply pong, got %d %q", w.Code, w.Body)
+	}
+}
+
+// readEvent reads the lines of a Server-Sent Event up to the blank line
+// ending it
+func readEvent(t *testing.T, stream *bufio.Reader) string {
+	t.Helper()
+
+	var event strings.Builder
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			return event.String()
+		}
+		event.WriteString(line)
+	}
+}
+
+func TestHTTPServerSentEvents(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+
+	stream := subscribeHTTP(t, server.URL, "orders.*?event=topic", mediaEventStream)
+	if _, err := ps.PublishData(pubsub.Data{Source: "source", Topic: "orders.eu", ID: "m1", Data: []byte(`{"a":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []string{readEvent(t, stream), readEvent(t, stream)}
+	if frames[0] != "retry: 3000\n" {
+		t.Fatalf("want retry first, got %q", frames[0])
+	}
+	id, rest, _ := strings.Cut(frames[1], "\n")
+	event, data, _ := strings.Cut(rest, "\n")
+	if id != "id: m1" || event != "event: orders.eu" || !strings.HasPrefix(data, "data: {") {
+		t.Fatalf("unexpected event: %q", frames[1])
+	}
+	var got pubsub.Data
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(data), "data: ")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Topic != "orders.eu" || string(got.Data) != `{"a":1}` {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+}
+
+func TestHTTPLastEventID(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 2 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.SSERetry = 0
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+	for i := 1; i <= 4; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 3 and 4 are still in history
+	stream := subscribeHTTP(t, server.URL, "topic?lastEventId=2", mediaEventStream)
+	for _, want := range []string{"id: 3\n", "id: 4\n"} {
+		if event := readEvent(t, stream); !strings.HasPrefix(event, want) {
+			t.Fatalf("want event %q, got %q", want, event)
+		}
+	}
+
+	// 2 was evicted
+	stream = subscribeHTTP(t, server.URL, "topic", mediaEventStream, "Last-Event-ID", "1")
+	for _, want := range []string{"event: gap\ndata: {\"topic\":\"topic\",\"from\":2,\"to\":2}\n", "id: 3\n", "id: 4\n"} {
+		if event := readEvent(t, stream); !strings.HasPrefix(event, want) {
+			t.Fatalf("want event %q, got %q", want, event)
+		}
+	}
+}
+
+func TestHTTPMultiTopicStream(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 10 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.SSERetry = 0
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+	for _, topic := range []string{"a", "a", "b"} {
+		if _, err := ps.Publish("source", topic, []byte(`1`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a resumes after its first message, b from the start
+	target := server.URL + "/a,b?topic=c.*&event=topic&lastEventId=" + url.QueryEscape("a=1&b=0")
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", mediaEventStream)
+	resp, err := http.DefaultClient.Do(req) // #nosec G107 -- test server URL
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeBody := sync.OnceValue(resp.Body.Close)
+	t.Cleanup(func() { _ = closeBody() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %d", resp.StatusCode)
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	// replays of both topics may interleave
+	replayed := readEvent(t, stream) + readEvent(t, stream)
+	if !strings.Contains(replayed, "event: a\n") || !strings.Contains(replayed, "event: b\n") {
+		t.Fatalf("want replay of a and b, got %q", replayed)
+	}
+	if _, err := ps.Publish("source", "c.x", []byte(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(t, stream); !strings.HasPrefix(event, "id: a=2&b=1\nevent: c.x\n") {
+		t.Fatalf("unexpected event: %q", event)
+	}
+
+	if err := closeBody(); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"a", "b", "c.x"} {
+		waitForUnsubscribe(t, ps, topic)
+	}
+}
+
+func TestHTTPMultiTopicStreamLimit(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.MaxStreamTopics = 2
+	})
+	for _, path := range []string{"/a,b,c", "/a?topic=b&topic=c", "/a,b?poll=true"} {
+		w := httptest.NewRecorder()
+		ht.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want status 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestHTTPResumeBacklogLargerThanBuffer(t *testing.T) {
+	t.Parallel()
+
+	const total = 200
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 1000 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.SSERetry = 0
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+	for i := 1; i <= total; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := subscribeHTTP(t, server.URL, "topic", mediaEventStream, "Last-Event-ID", "1")
+	for i := 2; i <= total; i++ {
+		if event := readEvent(t, stream); !strings.HasPrefix(event, fmt.Sprintf("id: %d\n", i)) {
+			t.Fatalf("want event %d, got %q", i, event)
+		}
+	}
+}
+
+func TestHTTPLastEventIDAhead(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 10 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.SSERetry = 0
+	})
+	server := httptest.NewServer(ht)
+	t.Cleanup(server.Close)
+	for i := 1; i <= 2; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the cursor was issued before a restart
+	stream := subscribeHTTP(t, server.URL, "topic", mediaEventStream, "Last-Event-ID", "500")
+	if event := readEvent(t, stream); event != "event: reset\ndata: {\"topic\":\"topic\"}\n" {
+		t.Fatalf("want reset event, got %q", event)
+	}
+	for _, want := range []string{"id: 1\n", "id: 2\n"} {
+		if event := readEvent(t, stream); !strings.HasPrefix(event, want) {
+			t.Fatalf("want event %q, got %q", want, event)
+		}
+	}
+	if _, err := ps.Publish("source", "topic", []byte(`3`)); err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(t, stream); !strings.HasPrefix(event, "id: 3\n") {
+		t.Fatalf("live message was skipped, got %q", event)
+	}
+}
@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+// HTTP headers carrying message metadata on publish requests. Message
+// headers are sent as HTTP headers prefixed with headerPrefix, which
+// canonicalizes their names, so relayed messages also carry them as JSON
+// in headerMessageHeaders to keep names exactly. Expiry and delay are
+// given either as absolute times or as durations relative to the request.
+const (
+	headerMessageID        = "X-Message-Id"
+	headerMessageHeaders   = "X-Message-Headers"
+	headerMessageTime      = "X-Message-Time"
+	headerMessageNode      = "X-Message-Node"
+	headerMessageExpiresAt = "X-Message-Expires-At"
+	headerMessageTTL       = "X-Message-Ttl"
+	headerMessageDeliverAt = "X-Message-Deliver-At"
+	headerMessageDelay     = "X-Message-Delay"
+	headerRetain           = "X-Retain"
+	headerReplyTo          = "X-Reply-To"
+	headerIdempotencyKey   = "Idempotency-Key"
+	headerPrefix           = "X-Header-"
+)
+
+// messageFromRequest builds a message published with an HTTP request.
+// Messages are retained with the X-Retain header or the retain query
+// parameter.
+func messageFromRequest(r *http.Request, topic, source string, data []byte) (pubsub.Data, error) {
+	msg := pubsub.Data{
+		Source:  source,
+		Topic:   topic,
+		ID:      r.Header.Get(headerMessageID),
+		Node:    r.Header.Get(headerMessageNode),
+		ReplyTo: r.Header.Get(headerReplyTo),
+	}
+	// the idempotency key becomes the message ID, which is relayed to
+	// other nodes so they drop the retries as well
+	if msg.ID == "" {
+		msg.ID = r.Header.Get(headerIdempotencyKey)
+	}
+	retain := r.Header.Get(headerRetain)
+	if retain == "" {
+		retain = r.URL.Query().Get("retain")
+	}
+	if retain != "" {
+		var err error
+		if msg.Retain, err = strconv.ParseBool(retain); err != nil {
+			return msg, fmt.Errorf("invalid retain flag %q", retain)
+		}
+	}
+	// an empty retained message clears the retained value
+	if !msg.Retain || len(data) > 0 {
+		if err := msg.SetPayload(r.Header.Get("Content-Type"), data); err != nil {
+			return msg, err
+		}
+	}
+	var err error
+	if msg.Time, err = timeHeader(r.Header, headerMessageTime); err != nil {
+		return msg, err
+	}
+	if msg.DeliverAt, err = timeHeader(r.Header, headerMessageDeliverAt); err != nil {
+		return msg, err
+	}
+	if msg.ExpiresAt, err = timeHeader(r.Header, headerMessageExpiresAt); err != nil {
+		return msg, err
+	}
+	now := time.Now().UTC()
+	if delay, ok, err := durationHeader(r.Header, headerMessageDelay); err != nil {
+		return msg, err
+	} else if ok {
+		msg.DeliverAt = now.Add(delay)
+	}
+	// the TTL of a delayed message starts when it is delivered
+	if ttl, ok, err := durationHeader(r.Header, headerMessageTTL); err != nil {
+		return msg, err
+	} else if ok {
+		msg.ExpiresAt = now.Add(ttl)
+		if msg.DeliverAt.After(now) {
+			msg.ExpiresAt = msg.DeliverAt.Add(ttl)
+		}
+	}
+	if headers := r.Header.Get(headerMessageHeaders); headers != "" {
+		if err := json.Unmarshal([]byte(headers), &msg.Headers); err != nil {
+			return msg, fmt.Errorf("invalid %s header: %w", headerMessageHeaders, err)
+		}
+		return msg, nil
+	}
+	for key, values := range r.Header {
+		name, ok := strings.CutPrefix(key, headerPrefix)
+		if !ok || name == "" || len(values) == 0 {
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[name] = values[0]
+	}
+	return msg, nil
+}
+
+func timeHeader(h http.Header, name string) (time.Time, error) {
+	value := h.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header: %w", name, err)
+	}
+	return t, nil
+}
+
+func durationHeader(h http.Header, name string) (time.Duration, bool, error) {
+	value := h.Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, false, fmt.Errorf("invalid %s header %q", name, value)
+	}
+	return d, true, nil
+}
+
+// setMessageHeaders writes message metadata as HTTP headers so another
+// node can rebuild the message with messageFromRequest
+func setMessageHeaders(h http.Header, msg pubsub.Data) {
+	if msg.ID != "" {
+		h.Set(headerMessageID, msg.ID)
+	}
+	if !msg.Time.IsZero() {
+		h.Set(headerMessageTime, msg.Time.Format(time.RFC3339Nano))
+	}
+	if msg.Node != "" {
+		h.Set(headerMessageNode, msg.Node)
+	}
+	if !msg.ExpiresAt.IsZero() {
+		h.Set(headerMessageExpiresAt, msg.ExpiresAt.Format(time.RFC3339Nano))
+	}
+	if !msg.DeliverAt.IsZero() {
+		h.Set(headerMessageDeliverAt, msg.DeliverAt.Format(time.RFC3339Nano))
+	}
+	if msg.Retain {
+		h.Set(headerRetain, "true")
+	}
+	if msg.ReplyTo != "" {
+		h.Set(headerReplyTo, msg.ReplyTo)
+	}
+	for name, value := range msg.Headers {
+		h.Set(headerPrefix+name, value)
+	}
+	if len(msg.Headers) > 0 {
+		// a map of strings always encodes
+		if headers, err := json.Marshal(msg.Headers); err == nil {
+			h.Set(headerMessageHeaders, string(headers))
+		}
+	}
+}
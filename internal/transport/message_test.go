@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+func TestMessageHeadersRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	want := pubsub.Data{
+		Data:    []byte(`{"a":1}`),
+		Source:  "source",
+		Topic:   "topic",
+		ID:      "id",
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Node:    "node",
+		Headers: map[string]string{"Trace-Id": "abc", "type": "created", "trace_id": "def"},
+		Retain:  true,
+	}
+	r := httptest.NewRequest(http.MethodPost, "/topic/source", nil)
+	setMessageHeaders(r.Header, want)
+
+	got, err := messageFromRequest(r, "topic", "source", want.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != want.ID || !got.Time.Equal(want.Time) || got.Node != want.Node || !got.Retain {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+	if !maps.Equal(got.Headers, want.Headers) {
+		t.Fatalf("unexpected headers: %v", got.Headers)
+	}
+}
+
+func TestMessageFromRequestInvalidTime(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/topic/source", nil)
+	r.Header.Set(headerMessageTime, "yesterday")
+	if _, err := messageFromRequest(r, "topic", "source", nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMessageFromRequestRetainQuery(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/topic/source?retain=true", nil)
+	msg, err := messageFromRequest(r, "topic", "source", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Retain || len(msg.Data) != 0 {
+		t.Fatalf("want empty retained message, got %+v", msg)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/topic/source?retain=maybe", nil)
+	if _, err := messageFromRequest(r, "topic", "source", []byte(`1`)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMessageFromRequestDelayAndTTL(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/topic/source", nil)
+	r.Header.Set(headerMessageDelay, "1m")
+	r.Header.Set(headerMessageTTL, "30s")
+	before := time.Now()
+	msg, err := messageFromRequest(r, "topic", "source", []byte(`1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.DeliverAt.Before(before.Add(time.Minute)) || msg.ExpiresAt.Sub(msg.DeliverAt) != 30*time.Second {
+		t.Fatalf("unexpected schedule: deliver at %s, expires at %s", msg.DeliverAt, msg.ExpiresAt)
+	}
+
+	relayed := httptest.NewRequest(http.MethodPost, "/topic/source", nil)
+	setMessageHeaders(relayed.Header, msg)
+	got, err := messageFromRequest(relayed, "topic", "source", []byte(`1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.DeliverAt.Equal(msg.DeliverAt) || !got.ExpiresAt.Equal(msg.ExpiresAt) {
+		t.Fatalf("want %+v, got %+v", msg, got)
+	}
+
+	r.Header.Set(headerMessageTTL, "soon")
+	if _, err := messageFromRequest(r, "topic", "source", []byte(`1`)); err == nil {
+		t.Fatal("expected error")
+	}
+}
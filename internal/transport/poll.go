@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+// pollResponse is a batch of messages returned to a long poll. Cursor
+// is passed on the next poll to continue after the batch.
+type pollResponse struct {
+	Messages []pubsub.Data `json:"messages"`
+	Cursor   uint64        `json:"cursor"`
+	// Missed reports messages evicted from history since the cursor.
+	Missed *gap `json:"missed,omitempty"`
+	// Reset reports a cursor ahead of the topic, issued before a restart
+	// or by another node. The batch starts over at the oldest message.
+	Reset bool `json:"reset,omitempty"`
+}
+
+// poll answers a long poll of topic with the messages published after
+// the cursor query parameter, waiting for the first one until the poll
+// times out with 204 No Content. Without a cursor the poll starts at
+// the oldest message in history.
+func (ht *HTTP) poll(w http.ResponseWriter, r *http.Request, topic string) {
+	if pubsub.IsPattern(topic) {
+		http.Error(w, "long polling needs a topic without wildcards", http.StatusBadRequest)
+		return
+	}
+	// without sequence numbers cursors can't tell which messages a poll
+	// missed
+	replayer, ok := ht.PubSub.(Replayer)
+	var last uint64
+	if ok {
+		last, ok = replayer.LastSeq(topic)
+	}
+	if !ok {
+		http.Error(w, "long polling needs message history", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	cursor, timeout, limit, err := ht.pollParams(query.Get("cursor"), query.Get("timeout"), query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reset := cursor > last
+	if reset {
+		cursor = 0
+	}
+
+	// every poll is a subscription of its own, blocking publishers only
+	// until the batch is taken, kept out of system events
+	id := r.RemoteAddr + "/poll/" + pubsub.NewID()
+	filter := query.Get("filter")
+	ch, err := replayer.SubscribeFrom(id, topic, cursor, func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = limit
+		o.Overflow = pubsub.Block
+		o.Filter = filter
+		o.Transport = "http"
+		o.Quiet = true
+	})
+	if err != nil {
+		ht.error(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	batch := collect(ctx, ch, limit)
+	cancel()
+	ht.PubSub.Unsubscribe(id, topic)
+
+	if len(batch) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	resp := pollResponse{Messages: batch, Cursor: batch[len(batch)-1].Seq, Reset: reset}
+	// filtered polls skip messages on purpose
+	if first := batch[0].Seq; cursor > 0 && filter == "" && first > cursor+1 {
+		resp.Missed = &gap{Topic: topic, From: cursor + 1, To: first - 1}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		ht.Log.Println(err)
+	}
+}
+
+// pollParams parses the query parameters of a long poll, timeout and
+// limit are capped by PollTimeout and PollLimit
+func (ht *HTTP) pollParams(cursorValue, timeoutValue, limitValue string) (cursor uint64, timeout time.Duration, limit int, err error) {
+	if cursorValue != "" {
+		if cursor, err = strconv.ParseUint(cursorValue, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid cursor %q", cursorValue)
+		}
+	}
+	timeout = ht.PollTimeout
+	if timeoutValue != "" {
+		d, err := time.ParseDuration(timeoutValue)
+		if err != nil || d < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid timeout %q", timeoutValue)
+		}
+		timeout = min(d, timeout)
+	}
+	limit = ht.PollLimit
+	if limitValue != "" {
+		n, err := strconv.Atoi(limitValue)
+		if err != nil || n < 1 {
+			return 0, 0, 0, fmt.Errorf("invalid limit %q", limitValue)
+		}
+		limit = min(n, limit)
+	}
+	return cursor, timeout, max(limit, 1), nil
+}
+
+// collect waits for the first message until ctx is done and adds the
+// messages already queued behind it, up to limit
+func collect(ctx context.Context, ch pubsub.DataChannel, limit int) []pubsub.Data {
+	var batch []pubsub.Data
+	select {
+	case m, ok := <-ch:
+		if !ok {
+			return nil
+		}
+		batch = append(batch, m)
+	case <-ctx.Done():
+		return nil
+	}
+	for len(batch) < limit {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+func pollHTTP(t *testing.T, ht *HTTP, path string) (int, pollResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	ht.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var resp pollResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestHTTPLongPoll(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 10 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+		ht.PollLimit = 2
+	})
+	for i := 1; i <= 3; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// batches follow the cursor through history
+	var got []string
+	path := "/topic?poll=true&timeout=20ms"
+	for len(got) < 3 {
+		code, resp := pollHTTP(t, ht, path)
+		if code != http.StatusOK || len(resp.Messages) == 0 || len(resp.Messages) > 2 || resp.Missed != nil {
+			t.Fatalf("unexpected poll %s: %d %+v", path, code, resp)
+		}
+		for _, m := range resp.Messages {
+			got = append(got, string(m.Data))
+		}
+		path = "/topic?poll=true&timeout=20ms&cursor=" + strconv.FormatUint(resp.Cursor, 10)
+	}
+	if len(got) != 3 || got[0] != "1" || got[2] != "3" {
+		t.Fatalf("want messages 1 to 3 in order, got %v", got)
+	}
+	if code, _ := pollHTTP(t, ht, path); code != http.StatusNoContent {
+		t.Fatalf("want poll without new messages to time out with 204, got %d", code)
+	}
+
+	// a waiting poll returns the next message
+	done := make(chan pollResponse)
+	go func() {
+		code, resp := pollHTTP(t, ht, "/topic?poll=true&timeout=5s&cursor=3")
+		if code != http.StatusOK {
+			t.Errorf("want status 200, got %d", code)
+		}
+		done <- resp
+	}()
+	// the message is replayed from history if it beats the poll
+	time.Sleep(20 * time.Millisecond)
+	if _, err := ps.Publish("source", "topic", []byte(`4`)); err != nil {
+		t.Fatal(err)
+	}
+	if resp := <-done; len(resp.Messages) != 1 || resp.Cursor != 4 {
+		t.Fatalf("unexpected poll response: %+v", resp)
+	}
+}
+
+func TestHTTPLongPollMissed(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 2 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	for i := 1; i <= 4; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, resp := pollHTTP(t, ht, "/topic?poll=true&cursor=1")
+	if code != http.StatusOK || resp.Missed == nil || resp.Missed.From != 2 || resp.Missed.To != 2 || resp.Messages[0].Seq != 3 {
+		t.Fatalf("want message 2 reported missed, got %d %+v", code, resp)
+	}
+}
+
+func TestHTTPLongPollCursorAhead(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 10 })
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	for i := 1; i <= 2; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the cursor was issued before a restart
+	code, resp := pollHTTP(t, ht, "/topic?poll=true&cursor=500")
+	if code != http.StatusOK || !resp.Reset || resp.Missed != nil || len(resp.Messages) != 2 || resp.Cursor != 2 {
+		t.Fatalf("want history replayed after a reset, got %d %+v", code, resp)
+	}
+}
+
+func TestHTTPLongPollWithoutSystemEvents(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New(func(ps *pubsub.PubSub) {
+		ps.HistorySize = 10
+		ps.SystemEvents = true
+	})
+	events, err := ps.Subscribe("all", pubsub.SystemPrefix+">", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 10
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	if _, err := ps.Publish("source", "topic", []byte(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := pollHTTP(t, ht, "/topic?poll=true"); code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", code)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("poll emitted system event %s", event.Data)
+	default:
+	}
+}
+
+func TestHTTPLongPollErrors(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	tests := map[string]int{
+		"/orders.*?poll=true":          http.StatusBadRequest,
+		"/topic?poll=maybe":            http.StatusBadRequest,
+		"/topic?poll=true&cursor=last": http.StatusBadRequest,
+		"/topic?poll=true&limit=0":     http.StatusBadRequest,
+	}
+	for path, want := range tests {
+		if code, _ := pollHTTP(t, ht, path); code != want {
+			t.Errorf("%s: want status %d, got %d", path, want, code)
+		}
+	}
+
+	ht.PubSub = &recordingPubSub{}
+	if code, _ := pollHTTP(t, ht, "/topic?poll=true"); code != http.StatusNotImplemented {
+		t.Fatalf("want status 501 without history, got %d", code)
+	}
+	ht.PubSub = pubsub.New(func(ps *pubsub.PubSub) { ps.HistorySize = 0 })
+	if code, _ := pollHTTP(t, ht, "/topic?poll=true"); code != http.StatusNotImplemented {
+		t.Fatalf("want status 501 with history disabled, got %d", code)
+	}
+}
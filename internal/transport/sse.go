@@ -0,0 +1,234 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+// Media types of subscriber streams. Subscribers get Server-Sent Events
+// unless they accept NDJSON, a JSON message per line.
+const (
+	mediaEventStream = "text/event-stream"
+	mediaNDJSON      = "application/x-ndjson"
+)
+
+// headerEventType is the message header naming the SSE event of
+// a message. Messages without it are plain message events.
+const headerEventType = "Type"
+
+// Values of the event query parameter of SSE subscribers
+const (
+	eventsByType  = "type"
+	eventsByTopic = "topic"
+)
+
+// streamType picks the stream format from an Accept header, the first
+// supported media type wins
+func streamType(accept string) string {
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case mediaEventStream, mediaNDJSON:
+			return mediaType
+		}
+	}
+	return mediaEventStream
+}
+
+// eventReset is the SSE event sent when a cursor of a topic is ahead of
+// its sequence, e.g. after a restart or when reconnecting to another
+// node. All history of the topic is replayed after it.
+const eventReset = "reset"
+
+// reset is the data of a reset event
+type reset struct {
+	Topic string `json:"topic"`
+}
+
+// eventGap is the SSE event sent in place of messages a subscriber of
+// a single topic missed, e.g. when they were evicted from history
+// before the subscriber reconnected
+const eventGap = "gap"
+
+// gap is the data of a gap event, the missed sequence numbers of topic
+// are From to To inclusive
+type gap struct {
+	Topic string `json:"topic"`
+	From  uint64 `json:"from"`
+	To    uint64 `json:"to"`
+}
+
+// sseEncoder writes messages as Server-Sent Events. The event ID is the
+// message sequence number, or its ID without history, and the data is
+// the JSON message.
+type sseEncoder struct {
+	w io.Writer
+	// byTopic names events after message topics instead of their type.
+	byTopic bool
+	// gaps reports skipped sequence numbers of topics subscribed
+	// without wildcards, which is only meaningful for streams of all
+	// their messages.
+	gaps bool
+	// composite makes event IDs of a stream of several topics carry the
+	// last sequence number of every topic, see lastEventIDs.
+	composite bool
+	// last holds the last sequence number by topic subscribed without
+	// wildcards.
+	last map[string]uint64
+	// resumed holds topics the subscriber sent a cursor of, gaps are
+	// only reported after it.
+	resumed map[string]bool
+}
+
+// fieldValue drops line breaks, which would end a field early
+var fieldValue = strings.NewReplacer("\r", "", "\n", "")
+
+// retry tells clients how long to wait before reconnecting
+func (e *sseEncoder) retry(d time.Duration) error {
+	_, err := fmt.Fprintf(e.w, "retry: %d\n\n", d.Milliseconds())
+	return err
+}
+
+// reset tells the subscriber the cursor of topic was unknown
+func (e *sseEncoder) reset(topic string) error {
+	data, err := json.Marshal(reset{Topic: topic})
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("event: " + eventReset + "\n")
+	writeData(&b, data)
+	_, err = e.w.Write(b.Bytes())
+	return err
+}
+
+// Encode writes msg as a single event, preceded by a gap event when
+// messages after the cursor of a resumed topic were missed
+func (e *sseEncoder) Encode(msg pubsub.Data) error {
+	var b bytes.Buffer
+	if last, tracked := lastSeq(e.last, e.resumed, msg); tracked {
+		// overlapping subscriptions deliver a message more than once
+		if msg.Seq <= last {
+			return nil
+		}
+		if e.gaps && e.resumed[msg.Topic] && last > 0 && msg.Seq > last+1 {
+			missed, err := json.Marshal(gap{Topic: msg.Topic, From: last + 1, To: msg.Seq - 1})
+			if err != nil {
+				return err
+			}
+			// a gap has no ID so Last-Event-ID stays the last message
+			b.WriteString("event: " + eventGap + "\n")
+			writeData(&b, missed)
+		}
+		e.last[msg.Topic] = msg.Seq
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	id := msg.ID
+	switch {
+	case e.composite:
+		id = e.cursor()
+	case msg.Seq > 0:
+		id = strconv.FormatUint(msg.Seq, 10)
+	}
+	if id != "" {
+		b.WriteString("id: " + fieldValue.Replace(id) + "\n")
+	}
+	event := eventType(msg.Headers)
+	if e.byTopic {
+		event = msg.Topic
+	}
+	if event != "" {
+		b.WriteString("event: " + fieldValue.Replace(event) + "\n")
+	}
+	writeData(&b, data)
+	_, err = e.w.Write(b.Bytes())
+	return err
+}
+
+// lastSeq returns the last sequence number a stream delivered of the
+// topic of msg, tracked is false for messages without a sequence number
+// and for topics not in last, i.e. subscribed with wildcards. Retained
+// messages replayed to a new subscriber are older than the live messages
+// following them, so they are only tracked for topics in resumed.
+func lastSeq(last map[string]uint64, resumed map[string]bool, msg pubsub.Data) (seq uint64, tracked bool) {
+	seq, tracked = last[msg.Topic]
+	if msg.Seq == 0 || (msg.Retain && !resumed[msg.Topic]) {
+		return seq, false
+	}
+	return seq, tracked
+}
+
+// ndjsonEncoder writes messages as JSON lines. Like sseEncoder it drops
+// messages delivered again by overlapping subscriptions of the stream.
+type ndjsonEncoder struct {
+	enc *json.Encoder
+	// last holds the last sequence number by topic subscribed without
+	// wildcards.
+	last map[string]uint64
+	// resumed holds topics the subscriber sent a cursor of.
+	resumed map[string]bool
+}
+
+// Encode writes msg as a single line unless it was already written
+func (e *ndjsonEncoder) Encode(msg pubsub.Data) error {
+	if last, tracked := lastSeq(e.last, e.resumed, msg); tracked {
+		if msg.Seq <= last {
+			return nil
+		}
+		e.last[msg.Topic] = msg.Seq
+	}
+	return e.enc.Encode(msg)
+}
+
+// eventType returns the headerEventType header, matching its name
+// case-insensitively as WebSocket and TCP clients name headers freely
+func eventType(headers map[string]string) string {
+	if event, ok := headers[headerEventType]; ok {
+		return event
+	}
+	for name, value := range headers {
+		if strings.EqualFold(name, headerEventType) {
+			return value
+		}
+	}
+	return ""
+}
+
+// cursor returns the event ID of a composite stream
+func (e *sseEncoder) cursor() string {
+	values := make(url.Values, len(e.last))
+	for topic, seq := range e.last {
+		if seq > 0 {
+			values.Set(topic, strconv.FormatUint(seq, 10))
+		}
+	}
+	return values.Encode()
+}
+
+// writeData writes the data fields ending an event, every line of
+// a multi-line payload is a data field of its own
+func writeData(b *bytes.Buffer, data []byte) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimSuffix(line, []byte("\r")))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+}
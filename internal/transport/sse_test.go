@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+func TestStreamType(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"":                                mediaEventStream,
+		"*/*":                             mediaEventStream,
+		"text/event-stream":               mediaEventStream,
+		"application/x-ndjson":            mediaNDJSON,
+		"text/html, application/x-ndjson": mediaNDJSON,
+		"text/event-stream, application/x-ndjson": mediaEventStream,
+		"application/x-ndjson; charset=utf-8":     mediaNDJSON,
+	}
+	for accept, want := range tests {
+		if got := streamType(accept); got != want {
+			t.Errorf("%q: want %s, got %s", accept, want, got)
+		}
+	}
+}
+
+func TestSSEEncoder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		byTopic bool
+		msg     pubsub.Data
+		want    string
+	}{
+		{
+			name: "message event",
+			msg:  pubsub.Data{Topic: "t", ID: "m1", Data: []byte(`1`)},
+			want: "id: m1\ndata: {\"data\":1,\"source\":\"\",\"topic\":\"t\",\"id\":\"m1\"}\n\n",
+		},
+		{
+			name: "sequence and type",
+			msg:  pubsub.Data{Topic: "t", ID: "m1", Seq: 7, Data: []byte(`1`), Headers: map[string]string{"Type": "order\ncreated"}},
+			want: "id: 7\nevent: ordercreated\ndata: ",
+		},
+		{
+			name: "lowercase type",
+			msg:  pubsub.Data{Topic: "t", Seq: 7, Data: []byte(`1`), Headers: map[string]string{"type": "created"}},
+			want: "id: 7\nevent: created\ndata: ",
+		},
+		{
+			name:    "topic event",
+			byTopic: true,
+			msg:     pubsub.Data{Topic: "orders.eu", Data: []byte(`1`), Headers: map[string]string{"Type": "created"}},
+			want:    "event: orders.eu\ndata: ",
+		},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := (&sseEncoder{w: &b, byTopic: tt.byTopic}).Encode(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); !strings.HasPrefix(got, tt.want) || !strings.HasSuffix(got, "}\n\n") {
+			t.Errorf("%s: unexpected event %q", tt.name, got)
+		}
+	}
+}
+
+func TestSSEEncoderGap(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	enc := &sseEncoder{w: &b, gaps: true, last: map[string]uint64{"t": 2}, resumed: map[string]bool{"t": true}}
+	for _, seq := range []uint64{3, 6} {
+		if err := enc.Encode(pubsub.Data{Topic: "t", Seq: seq, Data: []byte(`1`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := strings.Split(strings.TrimSuffix(b.String(), "\n\n"), "\n\n")
+	if len(events) != 3 || !strings.HasPrefix(events[0], "id: 3\n") || !strings.HasPrefix(events[2], "id: 6\n") {
+		t.Fatalf("unexpected events: %q", events)
+	}
+	if events[1] != "event: gap\ndata: {\"topic\":\"t\",\"from\":4,\"to\":5}" {
+		t.Fatalf("unexpected gap event: %q", events[1])
+	}
+}
+
+func TestSSEEncoderRetainedNoGap(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	// a new subscriber gets the retained message first
+	enc := &sseEncoder{w: &b, gaps: true, last: map[string]uint64{"t": 0}}
+	for _, msg := range []pubsub.Data{
+		{Topic: "t", Seq: 1, Retain: true},
+		{Topic: "t", Seq: 4},
+		{Topic: "t", Seq: 6},
+	} {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.String(); strings.Contains(got, "event: gap") {
+		t.Fatalf("unexpected gap without a cursor: %q", got)
+	}
+}
+
+func TestSSEEncoderComposite(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	enc := &sseEncoder{w: &b, composite: true, last: map[string]uint64{"a": 0, "b": 7}}
+	for _, msg := range []pubsub.Data{
+		{Topic: "a", Seq: 1},
+		{Topic: "b", Seq: 8},
+		// delivered again by an overlapping subscription
+		{Topic: "b", Seq: 8},
+		// a topic matched by a pattern is not tracked
+		{Topic: "c", Seq: 3},
+	} {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := strings.Split(strings.TrimSuffix(b.String(), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("want 3 events, got %q", events)
+	}
+	for i, id := range []string{"a=1&b=7", "a=1&b=8", "a=1&b=8"} {
+		if !strings.HasPrefix(events[i], "id: "+id+"\n") {
+			t.Errorf("event %d: want id %q, got %q", i, id, events[i])
+		}
+	}
+}
+
+func TestNDJSONEncoderDropsDuplicates(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	enc := &ndjsonEncoder{enc: json.NewEncoder(&b), last: map[string]uint64{"orders.eu": 0}}
+	for _, msg := range []pubsub.Data{
+		{Topic: "orders.eu", Seq: 1, Data: []byte(`1`)},
+		// delivered again by the overlapping subscription of orders.*
+		{Topic: "orders.eu", Seq: 1, Data: []byte(`1`)},
+		// a topic matched by a pattern is not tracked
+		{Topic: "orders.us", Seq: 1, Data: []byte(`2`)},
+		{Topic: "orders.us", Seq: 1, Data: []byte(`2`)},
+	} {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := strings.Count(b.String(), "\n"); lines != 3 {
+		t.Fatalf("want 3 lines, got %q", b.String())
+	}
+}
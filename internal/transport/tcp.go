@@ -5,35 +5,81 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/rkorkosz/go-hook/pkg/pubsub"
 )
 
 // TCP implements transport interface over tcp protocol
 type TCP struct {
-	Servers     Servers
-	PubSub      PubSub
-	Config      net.ListenConfig
-	Log         *log.Logger
-	PubAddress  string
-	SubAddress  string
+	Servers    Servers
+	PubSub     PubSub
+	Config     net.ListenConfig
+	Log        *log.Logger
+	PubAddress string
+	SubAddress string
+	// Subscription configures queues of subscribers connected over TCP.
+	Subscription pubsub.SubscribeOptions
+	// PublishInterceptors run on messages published over TCP before
+	// they reach PubSub. Delivery interceptors are set in Subscription.
+	PublishInterceptors []pubsub.PublishInterceptor
+	// RequestTimeout caps how long a request frame waits for its reply.
+	RequestTimeout time.Duration
+
 	started     chan struct{}
 	pubListener net.Listener
 	subListener net.Listener
 }
 
+// subscribeRequest is the handshake frame opening a subscriber connection
+type subscribeRequest struct {
+	Source string `json:"source"`
+	Topic  string `json:"topic"`
+	// Group joins a consumer group sharing messages of the topic.
+	Group string `json:"group,omitempty"`
+	// Filter is an expression messages have to match, see
+	// pubsub.CompileFilter.
+	Filter string `json:"filter,omitempty"`
+}
+
+// publishFrame is a message written to a publisher connection. A frame
+// with a Request correlation ID is published as a request and answered
+// with a replyFrame carrying the same ID, replies may come out of order.
+type publishFrame struct {
+	pubsub.Data
+	Request string `json:"request,omitempty"`
+	// Timeout shortens RequestTimeout for this request.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// replyFrame answers a request frame with the reply or the error code
+// of the failed request
+type replyFrame struct {
+	Request string       `json:"request"`
+	Reply   *pubsub.Data `json:"reply,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Code    string       `json:"code,omitempty"`
+}
+
 // NewTCP creates new TCP object
 func NewTCP(opts ...func(*TCP)) *TCP {
 	t := TCP{
-		PubSub:     pubsub.New(100),
-		PubAddress: ":9000",
-		SubAddress: ":9001",
-		Log:        log.New(os.Stdout, "[TCP] ", log.LstdFlags),
-		started:    make(chan struct{}, 2),
+		PubSub:         pubsub.New(),
+		PubAddress:     ":9000",
+		SubAddress:     ":9001",
+		Log:            log.New(os.Stdout, "[TCP] ", log.LstdFlags),
+		RequestTimeout: 30 * time.Second,
+		Subscription: pubsub.SubscribeOptions{
+			BufferSize: 64,
+			Overflow:   pubsub.DropOldest,
+		},
+		started: make(chan struct{}, 2),
 	}
 	for _, opt := range opts {
 		opt(&t)
@@ -116,18 +162,97 @@ func (t *TCP) handlePub(conn net.Conn) {
 			t.Log.Printf("Error closing connection: %v", err)
 		}
 	}()
+	// requests wait for replies concurrently and are abandoned once the
+	// publisher disconnects
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
 	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	encode := func(frame any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(frame)
+	}
 	for {
-		var data pubsub.Data
-		err := dec.Decode(&data)
+		var frame publishFrame
+		err := dec.Decode(&frame)
 		if err != nil {
 			if err != io.EOF {
 				t.Log.Println(err)
 			}
 			return
 		}
-		t.PubSub.Publish(data.Source, data.Topic, data.Data)
+		data := frame.Data
+		err = pubsub.ValidateClientTopic(data.Topic)
+		if err == nil {
+			err = pubsub.Intercept(&data, t.PublishInterceptors)
+		}
+		if err == nil && frame.Request != "" {
+			wg.Go(func() {
+				if err := encode(t.request(ctx, frame, data)); err != nil {
+					t.Log.Println(err)
+				}
+			})
+			continue
+		}
+		if err == nil {
+			_, err = t.PubSub.PublishData(data)
+		}
+		if err != nil {
+			t.Log.Println(err)
+			if frame.Request != "" {
+				err = encode(replyFrame{Request: frame.Request, Error: err.Error(), Code: errorCode(err)})
+			} else {
+				err = encode(newErrorFrame(err))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// request publishes data of a request frame and waits for its reply
+func (t *TCP) request(ctx context.Context, frame publishFrame, data pubsub.Data) replyFrame {
+	resp := replyFrame{Request: frame.Request}
+	timeout := t.RequestTimeout
+	if frame.Timeout != "" {
+		d, err := time.ParseDuration(frame.Timeout)
+		if err != nil || d <= 0 {
+			resp.Error = fmt.Sprintf("invalid timeout %q", frame.Timeout)
+			resp.Code = "invalid_timeout"
+			return resp
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	requester, ok := t.PubSub.(Requester)
+	if !ok {
+		resp.Error = "requests are not supported"
+		resp.Code = "not_supported"
+		return resp
 	}
+	reply, err := requester.Request(ctx, data, func(o *pubsub.RequestOptions) {
+		o.Transport = "tcp"
+	})
+	if err != nil {
+		resp.Error = err.Error()
+		resp.Code = errorCode(err)
+		return resp
+	}
+	resp.Reply = &reply
+	return resp
 }
 
 func (t *TCP) handleSub(conn net.Conn) {
@@ -137,20 +262,28 @@ func (t *TCP) handleSub(conn net.Conn) {
 		}
 	}()
 
-	var data pubsub.Data
-	if err := json.NewDecoder(conn).Decode(&data); err != nil {
+	var req subscribeRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
 		if err != io.EOF {
 			t.Log.Println(err)
 		}
 		return
 	}
 
-	ch, err := t.PubSub.Subscribe(data.Source, data.Topic)
+	ch, err := t.PubSub.Subscribe(req.Source, req.Topic, func(o *pubsub.SubscribeOptions) {
+		*o = t.Subscription
+		o.Group = req.Group
+		o.Filter = req.Filter
+		o.Transport = "tcp"
+	})
 	if err != nil {
 		t.Log.Println(err)
+		if err := json.NewEncoder(conn).Encode(newErrorFrame(err)); err != nil {
+			t.Log.Println(err)
+		}
 		return
 	}
-	defer t.PubSub.Unsubscribe(data.Source, data.Topic)
+	defer t.PubSub.Unsubscribe(req.Source, req.Topic)
 
 	connDone := make(chan struct{})
 	go func() {
@@ -168,6 +301,7 @@ func (t *TCP) handleSub(conn net.Conn) {
 				return
 			}
 			if err := enc.Encode(m); err != nil {
+				deadLetter(t.PubSub, req.Source, m, err)
 				return
 			}
 		case <-connDone:
@@ -44,9 +44,11 @@ func TestTCPRunStopsWhenNoOneWaits(t *testing.T) {
 func TestTCPPublishesToSubscriber(t *testing.T) {
 	t.Parallel()
 
+	ps := pubsub.New()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	tcp := NewTCP(func(tcp *TCP) {
+		tcp.PubSub = ps
 		tcp.PubAddress = "127.0.0.1:0"
 		tcp.SubAddress = "127.0.0.1:0"
 		tcp.Log = log.New(io.Discard, "", 0)
@@ -81,6 +83,7 @@ func TestTCPPublishesToSubscriber(t *testing.T) {
 	if err := json.NewEncoder(subConn).Encode(pubsub.Data{Source: "subscriber", Topic: "topic"}); err != nil {
 		t.Fatal(err)
 	}
+	waitForSubscription(t, ps, "subscriber", "topic")
 
 	pubConn, err := net.Dial("tcp", tcp.PubAddr())
 	if err != nil {
@@ -189,13 +192,132 @@ func TestTCPUnsubscribesWhenSubscriberDisconnects(t *testing.T) {
 	}
 }
 
+func TestTCPSubscribeLimitSendsErrorFrame(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tcp := NewTCP(func(tcp *TCP) {
+		tcp.PubSub = pubsub.New(func(ps *pubsub.PubSub) {
+			ps.MaxTopics = 0
+		})
+		tcp.PubAddress = "127.0.0.1:0"
+		tcp.SubAddress = "127.0.0.1:0"
+		tcp.Log = log.New(io.Discard, "", 0)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- tcp.Run(ctx) }()
+	tcp.Wait()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", tcp.SubAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Logf("Error closing connection: %v", err)
+		}
+	}()
+	if err := json.NewEncoder(conn).Encode(pubsub.Data{Source: "subscriber", Topic: "topic"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Logf("Error setting read deadline: %v", err)
+	}
+	var got errorFrame
+	if err := json.NewDecoder(conn).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "too_many_topics" {
+		t.Fatalf("unexpected error frame: %+v", got)
+	}
+}
+
+func TestTCPSubscribeJoinsGroup(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tcp := NewTCP(func(tcp *TCP) {
+		tcp.PubSub = ps
+		tcp.PubAddress = "127.0.0.1:0"
+		tcp.SubAddress = "127.0.0.1:0"
+		tcp.Log = log.New(io.Discard, "", 0)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- tcp.Run(ctx) }()
+	tcp.Wait()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var conns []net.Conn
+	for _, id := range []string{"a", "b"} {
+		conn, err := net.Dial("tcp", tcp.SubAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				t.Logf("Error closing connection: %v", err)
+			}
+		}()
+		if err := json.NewEncoder(conn).Encode(subscribeRequest{Source: id, Topic: "topic", Group: "workers"}); err != nil {
+			t.Fatal(err)
+		}
+		waitForSubscription(t, ps, id, "topic")
+		conns = append(conns, conn)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(`{"a":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, conn := range conns {
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Logf("Error setting read deadline: %v", err)
+		}
+		var got pubsub.Data
+		if err := json.NewDecoder(conn).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Topic != "topic" {
+			t.Fatalf("unexpected message: %+v", got)
+		}
+	}
+}
+
+func waitForSubscription(t *testing.T, ps *pubsub.PubSub, id, topic string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := ps.Stats(id, topic); ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("subscription %s/%s was not registered", id, topic)
+}
+
 type recordingPubSub struct {
 	subscribed   chan struct{}
 	unsubscribed chan pubsub.Data
 	messages     chan pubsub.Data
 }
 
-func (ps *recordingPubSub) Subscribe(id, topic string) (pubsub.DataChannel, error) {
+func (ps *recordingPubSub) Subscribe(id, topic string, opts ...func(*pubsub.SubscribeOptions)) (pubsub.DataChannel, error) {
 	ps.messages = make(chan pubsub.Data)
 	ps.subscribed <- struct{}{}
 	return ps.messages, nil
@@ -208,4 +330,132 @@ func (ps *recordingPubSub) Unsubscribe(id, topic string) {
 	}
 }
 
-func (ps *recordingPubSub) Publish(source, topic string, data []byte) {}
+func (ps *recordingPubSub) PublishData(msg pubsub.Data) (pubsub.Report, error) {
+	return pubsub.Report{Message: msg}, nil
+}
+
+func TestTCPSubscribeFilter(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tcp := NewTCP(func(tcp *TCP) {
+		tcp.PubSub = ps
+		tcp.PubAddress = "127.0.0.1:0"
+		tcp.SubAddress = "127.0.0.1:0"
+		tcp.Log = log.New(io.Discard, "", 0)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- tcp.Run(ctx) }()
+	tcp.Wait()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", tcp.SubAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Logf("Error closing connection: %v", err)
+		}
+	}()
+	if err := json.NewEncoder(conn).Encode(subscribeRequest{Source: "id", Topic: "topic", Filter: `data.a == 2`}); err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscription(t, ps, "id", "topic")
+
+	for _, body := range []string{`{"a":1}`, `{"a":2}`} {
+		if _, err := ps.Publish("source", "topic", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Logf("Error setting read deadline: %v", err)
+	}
+	var got pubsub.Data
+	if err := json.NewDecoder(conn).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Data) != `{"a":2}` {
+		t.Fatalf("unexpected message: %s", got.Data)
+	}
+}
+
+func TestTCPRequestReply(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	ch, err := ps.Subscribe("responder", "rpc", func(o *pubsub.SubscribeOptions) {
+		o.BufferSize = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range ch {
+			if _, err := ps.Publish("responder", msg.ReplyTo, []byte(`"pong"`)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tcp := NewTCP(func(tcp *TCP) {
+		tcp.PubSub = ps
+		tcp.PubAddress = "127.0.0.1:0"
+		tcp.SubAddress = "127.0.0.1:0"
+		tcp.Log = log.New(io.Discard, "", 0)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- tcp.Run(ctx) }()
+	tcp.Wait()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", tcp.PubAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Logf("Error closing connection: %v", err)
+		}
+	}()
+	enc := json.NewEncoder(conn)
+	for _, frame := range []publishFrame{
+		{Data: pubsub.Data{Source: "client", Topic: "nobody", Data: []byte(`"ping"`)}, Request: "1"},
+		{Data: pubsub.Data{Source: "client", Topic: "rpc", Data: []byte(`"ping"`)}, Request: "2"},
+	} {
+		if err := enc.Encode(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Logf("Error setting read deadline: %v", err)
+	}
+	dec := json.NewDecoder(conn)
+	got := make(map[string]replyFrame)
+	for range 2 {
+		var frame replyFrame
+		if err := dec.Decode(&frame); err != nil {
+			t.Fatal(err)
+		}
+		got[frame.Request] = frame
+	}
+	if got["1"].Code != "no_responders" {
+		t.Fatalf("want no responders for request 1, got %+v", got["1"])
+	}
+	if reply := got["2"].Reply; reply == nil || string(reply.Data) != `"pong"` || reply.Source != "responder" {
+		t.Fatalf("unexpected reply to request 2: %+v", got["2"])
+	}
+}
@@ -1,16 +1,19 @@
 package transport
 
 import (
+	"context"
+	"errors"
+
 	"github.com/rkorkosz/go-hook/pkg/pubsub"
 )
 
 type Subscriber interface {
-	Subscribe(id, topic string) (pubsub.DataChannel, error)
+	Subscribe(id, topic string, opts ...func(*pubsub.SubscribeOptions)) (pubsub.DataChannel, error)
 	Unsubscribe(id, topic string)
 }
 
 type Publisher interface {
-	Publish(source, topic string, data []byte)
+	PublishData(msg pubsub.Data) (pubsub.Report, error)
 }
 
 type PubSub interface {
@@ -18,6 +21,86 @@ type PubSub interface {
 	Subscriber
 }
 
+// Replayer is implemented by a PubSub keeping message history, which lets
+// subscribers resume after the last message they received, see
+// pubsub.PubSub.SubscribeFrom and pubsub.PubSub.LastSeq
+type Replayer interface {
+	SubscribeFrom(id, topic string, seq uint64, opts ...func(*pubsub.SubscribeOptions)) (pubsub.DataChannel, error)
+	LastSeq(topic string) (uint64, bool)
+}
+
+// Requester is implemented by a PubSub sending requests and waiting for
+// their replies, see pubsub.PubSub.Request
+type Requester interface {
+	Request(ctx context.Context, msg pubsub.Data, opts ...func(*pubsub.RequestOptions)) (pubsub.Data, error)
+}
+
+// DeadLetterer is implemented by a PubSub keeping messages transports
+// fail to deliver, see pubsub.PubSub.DeadLetter
+type DeadLetterer interface {
+	DeadLetter(id string, msg pubsub.Data, reason string)
+}
+
+// deadLetter hands msg lost by subscriber id to ps if it keeps them
+func deadLetter(ps PubSub, id string, msg pubsub.Data, err error) {
+	if dl, ok := ps.(DeadLetterer); ok {
+		dl.DeadLetter(id, msg, "delivery failed: "+err.Error())
+	}
+}
+
+// Relayer relays messages published on this server to the other servers
+// of a cluster
+type Relayer interface {
+	Relay(msg pubsub.Data)
+}
+
 type Servers interface {
 	Iter() chan string
 }
+
+// errorFrame is written to stream clients when a request fails
+type errorFrame struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func newErrorFrame(err error) errorFrame {
+	return errorFrame{Error: err.Error(), Code: errorCode(err)}
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, pubsub.ErrReservedTopic):
+		return "reserved_topic"
+	case errors.Is(err, pubsub.ErrInvalidTopic):
+		return "invalid_topic"
+	case errors.Is(err, pubsub.ErrInvalidPayload):
+		return "invalid_payload"
+	case errors.Is(err, pubsub.ErrInvalidFilter):
+		return "invalid_filter"
+	case errors.Is(err, errInvalidRequest):
+		return "invalid_request"
+	case errors.Is(err, pubsub.ErrRejected):
+		return "rejected"
+	case errors.Is(err, pubsub.ErrMessageTooLarge):
+		return "message_too_large"
+	case errors.Is(err, pubsub.ErrTooManySubscribers):
+		return "too_many_subscribers"
+	case errors.Is(err, pubsub.ErrTooManyTopics):
+		return "too_many_topics"
+	case errors.Is(err, pubsub.ErrTooManySubscriptions):
+		return "too_many_subscriptions"
+	case errors.Is(err, pubsub.ErrTooManyRetained):
+		return "too_many_retained"
+	case errors.Is(err, pubsub.ErrTooManyScheduled):
+		return "too_many_scheduled"
+	case errors.Is(err, pubsub.ErrNoResponders):
+		return "no_responders"
+	case errors.Is(err, pubsub.ErrTooManyRequests):
+		return "too_many_requests"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "internal"
+	}
+}
@@ -0,0 +1,368 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+// errInvalidRequest is returned for malformed WebSocket control frames
+var errInvalidRequest = errors.New("invalid request")
+
+// WebSocket represents transport over WebSocket connections. Clients
+// send JSON control frames to subscribe to and unsubscribe from any
+// number of topics and to publish, and receive messages of all their
+// subscriptions over the same socket.
+type WebSocket struct {
+	Server *http.Server
+	PubSub PubSub
+	Log    *log.Logger
+	// Subscription configures queues of subscribers connected over
+	// WebSocket.
+	Subscription pubsub.SubscribeOptions
+	// PublishInterceptors run on messages published over WebSocket before
+	// they reach PubSub. Delivery interceptors are set in Subscription.
+	PublishInterceptors []pubsub.PublishInterceptor
+	// MaxMessageSize caps messages read from clients in bytes, it can't
+	// exceed 64 MiB, which is also the limit when it is zero.
+	MaxMessageSize int64
+	// Relay relays messages published over WebSocket to other servers,
+	// e.g. by the HTTP transport of the cluster.
+	Relay Relayer
+	// PingInterval is how often clients are pinged. Connections silent
+	// for two intervals are closed, zero disables keepalive.
+	PingInterval time.Duration
+
+	shutdown context.Context
+	cancel   context.CancelFunc
+}
+
+// Operations of WebSocket control frames. Clients send subscribe,
+// unsubscribe and publish, each answered with ack or error, and receive
+// message frames of their subscriptions.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsPublish     = "publish"
+	wsAck         = "ack"
+	wsError       = "error"
+	wsMessage     = "message"
+)
+
+// wsRequest is a control frame sent by a WebSocket client
+type wsRequest struct {
+	Op string `json:"op"`
+	// Ref is echoed in the answer to the request.
+	Ref    string `json:"ref,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Filter string `json:"filter,omitempty"`
+	// Message is published by publish requests.
+	Message *pubsub.Data `json:"message,omitempty"`
+}
+
+// wsResponse is a frame sent to a WebSocket client, either the answer
+// to a request or a message of a subscription
+type wsResponse struct {
+	Op  string `json:"op"`
+	Ref string `json:"ref,omitempty"`
+	// Topic is the subscribed pattern a message matched.
+	Topic   string       `json:"topic,omitempty"`
+	Message *pubsub.Data `json:"message,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Code    string       `json:"code,omitempty"`
+}
+
+// NewWebSocket creates WebSocket object with sensible defaults
+func NewWebSocket(opts ...func(*WebSocket)) *WebSocket {
+	ws := &WebSocket{
+		Server: &http.Server{
+			Addr:              ":8001",
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		PubSub: pubsub.New(),
+		Log:    log.New(os.Stdout, "[WS] ", log.LstdFlags),
+		Subscription: pubsub.SubscribeOptions{
+			BufferSize: 64,
+			Overflow:   pubsub.DropOldest,
+		},
+		MaxMessageSize: 1 << 20,
+		PingInterval:   30 * time.Second,
+	}
+	ws.Server.Handler = ws
+	for _, opt := range opts {
+		opt(ws)
+	}
+	// hijacked connections are not closed by Shutdown
+	ws.shutdown, ws.cancel = context.WithCancel(context.Background())
+	ws.Server.RegisterOnShutdown(ws.cancel)
+	return ws
+}
+
+// Run creates a main transport loop
+func (ws *WebSocket) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		ws.Log.Printf("Starting server on addr %s\n", ws.Server.Addr)
+		if err := ws.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		if err := ws.Server.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	case err := <-errCh:
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves it
+// until either side closes it
+func (ws *WebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrade(w, r)
+	if err != nil {
+		ws.Log.Println(err)
+		return
+	}
+	conn.maxSize = ws.MaxMessageSize
+	conn.readTimeout = 2 * ws.PingInterval
+
+	source := r.RemoteAddr
+	if source == "" {
+		source = "local"
+	}
+	s := &wsSession{ws: ws, conn: conn, id: source, topics: make(map[string]pubsub.DataChannel)}
+	s.serve(r.Context())
+}
+
+// wsSession is a connected WebSocket client. Its subscriptions share the
+// client address as subscriber ID.
+type wsSession struct {
+	ws   *WebSocket
+	conn *wsConn
+	id   string
+
+	mu     sync.Mutex
+	topics map[string]pubsub.DataChannel
+	wg     sync.WaitGroup
+}
+
+func (s *wsSession) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ws.shutdown, func() {
+		if err := s.conn.close(closeGoingAway, "server shutting down"); err != nil {
+			s.ws.Log.Println(err)
+		}
+	})
+	defer stop()
+	defer s.close()
+	// read errors of dropped connections leave them open
+	defer func() {
+		if err := s.conn.drop(); err != nil {
+			s.ws.Log.Println(err)
+		}
+	}()
+	if s.ws.PingInterval > 0 {
+		go s.keepalive(ctx)
+	}
+
+	for {
+		op, data, err := s.conn.readMessage()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if op != opText {
+			s.fail(&closeError{code: closeUnsupportedData, reason: "only text frames are supported"})
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.answer(req, fmt.Errorf("%w: %w", errInvalidRequest, err))
+			continue
+		}
+		s.handle(req)
+	}
+}
+
+// fail closes the connection with a close frame after a read error,
+// unless the client went away
+func (s *wsSession) fail(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	code, reason := closeGoingAway, ""
+	if ce, ok := errors.AsType[*closeError](err); ok {
+		code, reason = ce.code, ce.reason
+	}
+	s.ws.Log.Println(err)
+	if err := s.conn.close(code, reason); err != nil {
+		s.ws.Log.Println(err)
+	}
+}
+
+func (s *wsSession) handle(req wsRequest) {
+	switch req.Op {
+	case wsSubscribe:
+		ch, err := s.subscribe(req)
+		s.answer(req, err)
+		if ch != nil {
+			s.wg.Go(func() { s.forward(req.Topic, ch) })
+		}
+	case wsUnsubscribe:
+		s.answer(req, s.unsubscribe(req.Topic))
+	case wsPublish:
+		s.answer(req, s.publish(req.Message))
+	default:
+		s.answer(req, fmt.Errorf("%w: unknown op %q", errInvalidRequest, req.Op))
+	}
+}
+
+// answer acknowledges req or reports its error
+func (s *wsSession) answer(req wsRequest, err error) {
+	resp := wsResponse{Op: wsAck, Ref: req.Ref}
+	if err != nil {
+		resp = wsResponse{Op: wsError, Ref: req.Ref, Error: err.Error(), Code: errorCode(err)}
+	}
+	if err := s.send(resp); err != nil {
+		s.ws.Log.Println(err)
+	}
+}
+
+func (s *wsSession) send(resp wsResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.conn.writeFrame(opText, data)
+}
+
+// subscribe returns the channel of a new subscription, or nil if the
+// client is already subscribed to the topic
+func (s *wsSession) subscribe(req wsRequest) (pubsub.DataChannel, error) {
+	if req.Topic == "" {
+		return nil, fmt.Errorf("%w: missing topic", errInvalidRequest)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[req.Topic]; ok {
+		return nil, nil
+	}
+	ch, err := s.ws.PubSub.Subscribe(s.id, req.Topic, func(o *pubsub.SubscribeOptions) {
+		*o = s.ws.Subscription
+		o.Group = req.Group
+		o.Filter = req.Filter
+		o.Transport = "websocket"
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.topics[req.Topic] = ch
+	return ch, nil
+}
+
+func (s *wsSession) unsubscribe(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("%w: missing topic", errInvalidRequest)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[topic]; ok {
+		delete(s.topics, topic)
+		s.ws.PubSub.Unsubscribe(s.id, topic)
+	}
+	return nil
+}
+
+func (s *wsSession) publish(msg *pubsub.Data) error {
+	if msg == nil {
+		return fmt.Errorf("%w: missing message", errInvalidRequest)
+	}
+	data := *msg
+	if data.Source == "" {
+		data.Source = s.id
+	}
+	if err := pubsub.ValidateClientTopic(data.Topic); err != nil {
+		return fmt.Errorf("%w: %q", err, data.Topic)
+	}
+	if err := pubsub.Intercept(&data, s.ws.PublishInterceptors); err != nil {
+		return err
+	}
+	report, err := s.ws.PubSub.PublishData(data)
+	if err != nil {
+		return err
+	}
+	if s.ws.Relay != nil && !report.Duplicate {
+		s.ws.Relay.Relay(report.Message)
+	}
+	return nil
+}
+
+// forward writes messages of the subscription to topic until it is
+// removed. A subscription PubSub removes, e.g. to disconnect a slow
+// client, is forgotten so the client can subscribe to topic again.
+func (s *wsSession) forward(topic string, ch pubsub.DataChannel) {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.topics[topic] == ch {
+			delete(s.topics, topic)
+		}
+	}()
+	for m := range ch {
+		if err := s.send(wsResponse{Op: wsMessage, Topic: topic, Message: &m}); err != nil {
+			s.ws.Log.Println(err)
+			deadLetter(s.ws.PubSub, s.id, m, err)
+			// a broken connection ends the session
+			if err := s.conn.close(closeInternalError, "write failed"); err != nil {
+				s.ws.Log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// keepalive pings the client until ctx is done, pongs extend the read
+// deadline of the connection
+func (s *wsSession) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(s.ws.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close removes all subscriptions of the session and waits for their
+// forwarders
+func (s *wsSession) close() {
+	s.mu.Lock()
+	for topic := range s.topics {
+		s.ws.PubSub.Unsubscribe(s.id, topic)
+	}
+	clear(s.topics)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
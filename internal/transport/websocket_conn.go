@@ -0,0 +1,337 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/sha1" // #nosec G505 -- SHA-1 is mandated by the RFC 6455 handshake
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes of RFC 6455
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// WebSocket close codes of RFC 6455
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeUnsupportedData = 1003
+	closeNoStatus        = 1005
+	closeInvalidPayload  = 1007
+	closeMessageTooBig   = 1009
+	closeInternalError   = 1011
+)
+
+// wsGUID is appended to the client key to compute Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsWriteTimeout bounds writing a frame to a client
+const wsWriteTimeout = 10 * time.Second
+
+// wsMaxMessageSize caps messages of connections without maxSize, so
+// a frame header can't make the server allocate gigabytes
+const wsMaxMessageSize = 64 << 20
+
+// closeError is a violation ending a connection with a close code
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket: %s (%d)", e.reason, e.code)
+}
+
+func protocolError(reason string) error {
+	return &closeError{code: closeProtocolError, reason: reason}
+}
+
+// acceptKey computes the Sec-WebSocket-Accept header of a client key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID)) // #nosec G401 -- not used for security
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma separated header contains
+// token, ignoring case
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade performs the server side of the opening handshake and takes
+// over the connection. A failed handshake is answered with an HTTP error.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, errors.New("websocket: not a handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		http.Error(w, "invalid websocket key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, err
+	}
+	c := &wsConn{conn: conn, r: rw.Reader, w: rw.Writer}
+	if err := conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	return c, nil
+}
+
+// wsConn is the server side of a WebSocket connection. Messages are read
+// by a single goroutine, writes may come from any.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// maxSize caps the size of a message, zero means wsMaxMessageSize.
+	maxSize int64
+	// readTimeout closes connections silent for longer, zero means no
+	// timeout.
+	readTimeout time.Duration
+
+	mu     sync.Mutex // serializes writes
+	w      *bufio.Writer
+	closed bool
+}
+
+// limit returns the maximum size of a message
+func (c *wsConn) limit() int64 {
+	if c.maxSize <= 0 || c.maxSize > wsMaxMessageSize {
+		return wsMaxMessageSize
+	}
+	return c.maxSize
+}
+
+// readMessage returns the next text or binary message, joining
+// fragments and answering pings on the way. A close frame of the client
+// is answered and ends the connection with io.EOF.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var (
+		op  byte
+		msg []byte
+	)
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.closeReceived(payload)
+		case opText, opBinary:
+			if op != 0 {
+				return 0, nil, protocolError("expected continuation frame")
+			}
+			op, msg = frameOp, payload
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, protocolError("unexpected continuation frame")
+			}
+			if int64(len(msg)+len(payload)) > c.limit() {
+				return 0, nil, &closeError{code: closeMessageTooBig, reason: "message too large"}
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, protocolError("unknown opcode")
+		}
+		if !fin {
+			continue
+		}
+		if op == opText && !utf8.Valid(msg) {
+			return 0, nil, &closeError{code: closeInvalidPayload, reason: "invalid UTF-8 text"}
+		}
+		return op, msg, nil
+	}
+}
+
+// readFrame reads and unmasks a single frame
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, protocolError("reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, protocolError("client frames must be masked")
+	}
+
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || size > 125) {
+		return false, 0, nil, protocolError("invalid control frame")
+	}
+	if size > uint64(c.limit()) {
+		return false, 0, nil, &closeError{code: closeMessageTooBig, reason: "message too large"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// closeReceived answers a close frame of the client with its code
+func (c *wsConn) closeReceived(payload []byte) error {
+	code := closeNoStatus
+	switch {
+	case len(payload) == 1:
+		return protocolError("invalid close frame")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		if !utf8.Valid(payload[2:]) {
+			return &closeError{code: closeInvalidPayload, reason: "invalid close reason"}
+		}
+	}
+	if code == closeNoStatus {
+		code = closeNormal
+	}
+	if err := c.close(code, ""); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// writeFrame writes an unfragmented frame, servers don't mask frames
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.write(op, payload)
+}
+
+func (c *wsConn) write(op byte, payload []byte) error {
+	head := make([]byte, 2, 10)
+	head[0] = 0x80 | op
+	switch size := len(payload); {
+	case size <= 125:
+		head[1] = byte(size)
+	case size <= 0xffff:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(size))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(size))
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// drop closes the connection without a close frame, e.g. after the
+// client went away. It does nothing once the connection is closed.
+func (c *wsConn) drop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// close sends a close frame and closes the connection, it is safe to
+// call more than once
+func (c *wsConn) close(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	// control frames carry at most 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code)) // #nosec G115 -- close codes fit 16 bits
+	payload = append(payload, reason...)
+	err := c.write(opClose, payload)
+	return errors.Join(err, c.conn.Close())
+}
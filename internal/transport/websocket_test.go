@@ -0,0 +1,415 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+// wsClient is a minimal WebSocket client writing masked frames
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server) *wsClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Logf("Error closing connection: %v", err)
+		}
+	})
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	if _, err := io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response: %d %v", resp.StatusCode, resp.Header)
+	}
+	return &wsClient{t: t, conn: conn, r: r}
+}
+
+func (c *wsClient) writeFrame(head byte, payload []byte) {
+	c.t.Helper()
+
+	frame := []byte{head, 0x80}
+	switch {
+	case len(payload) <= 125:
+		frame[1] |= byte(len(payload))
+	default:
+		frame[1] |= 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) send(req wsRequest) {
+	c.t.Helper()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.writeFrame(0x80|opText, data)
+}
+
+// readFrame reads an unmasked server frame
+func (c *wsClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func (c *wsClient) receive() wsResponse {
+	c.t.Helper()
+
+	op, data := c.readFrame()
+	if op != opText {
+		c.t.Fatalf("want text frame, got opcode %d", op)
+	}
+	var resp wsResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// closeCode reads the close frame ending the connection
+func (c *wsClient) closeCode() int {
+	c.t.Helper()
+
+	op, data := c.readFrame()
+	if op != opClose || len(data) < 2 {
+		c.t.Fatalf("want close frame, got opcode %d %q", op, data)
+	}
+	return int(binary.BigEndian.Uint16(data))
+}
+
+func newWebSocketServer(t *testing.T, ps PubSub) *httptest.Server {
+	t.Helper()
+
+	ws := NewWebSocket(func(ws *WebSocket) {
+		ws.PubSub = ps
+		ws.Log = log.New(io.Discard, "", 0)
+		ws.MaxMessageSize = 1024
+	})
+	server := httptest.NewServer(ws)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebSocketSubscribePublish(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	c := dialWebSocket(t, newWebSocketServer(t, ps))
+
+	c.send(wsRequest{Op: wsSubscribe, Ref: "1", Topic: "orders.*"})
+	c.send(wsRequest{Op: wsSubscribe, Ref: "2", Topic: "alerts"})
+	for _, ref := range []string{"1", "2"} {
+		if resp := c.receive(); resp.Op != wsAck || resp.Ref != ref {
+			t.Fatalf("want ack of %s, got %+v", ref, resp)
+		}
+	}
+
+	c.send(wsRequest{Op: wsPublish, Ref: "3", Message: &pubsub.Data{Topic: "orders.eu", Data: []byte(`{"a":1}`)}})
+	if resp := c.receive(); resp.Op != wsAck || resp.Ref != "3" {
+		t.Fatalf("want ack of publish, got %+v", resp)
+	}
+	resp := c.receive()
+	if resp.Op != wsMessage || resp.Topic != "orders.*" || resp.Message == nil || string(resp.Message.Data) != `{"a":1}` {
+		t.Fatalf("unexpected message frame: %+v", resp)
+	}
+
+	c.send(wsRequest{Op: wsUnsubscribe, Ref: "4", Topic: "orders.*"})
+	if resp := c.receive(); resp.Op != wsAck || resp.Ref != "4" {
+		t.Fatalf("want ack of unsubscribe, got %+v", resp)
+	}
+	if _, err := ps.Publish("source", "orders.eu", []byte(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Publish("source", "alerts", []byte(`2`)); err != nil {
+		t.Fatal(err)
+	}
+	if resp := c.receive(); resp.Topic != "alerts" || string(resp.Message.Data) != `2` {
+		t.Fatalf("want only the alert after unsubscribing, got %+v", resp)
+	}
+
+	c.send(wsRequest{Op: "dance", Ref: "5"})
+	if resp := c.receive(); resp.Op != wsError || resp.Ref != "5" || resp.Code != "invalid_request" {
+		t.Fatalf("want invalid request error, got %+v", resp)
+	}
+
+	c.writeFrame(0x80|opPing, []byte("hi"))
+	if op, data := c.readFrame(); op != opPong || string(data) != "hi" {
+		t.Fatalf("want pong, got opcode %d %q", op, data)
+	}
+
+	c.writeFrame(0x80|opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+	if code := c.closeCode(); code != closeNormal {
+		t.Fatalf("want close code %d, got %d", closeNormal, code)
+	}
+	waitForUnsubscribe(t, ps, "alerts")
+}
+
+func TestWebSocketResubscribeAfterRemoval(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	c := dialWebSocket(t, newWebSocketServer(t, ps))
+
+	c.send(wsRequest{Op: wsSubscribe, Ref: "1", Topic: "alerts"})
+	if resp := c.receive(); resp.Op != wsAck {
+		t.Fatalf("want ack, got %+v", resp)
+	}
+	// PubSub removes the subscription, e.g. to disconnect a slow client
+	ps.Unsubscribe(c.conn.LocalAddr().String(), "alerts")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.send(wsRequest{Op: wsSubscribe, Ref: "2", Topic: "alerts"})
+		if resp := c.receive(); resp.Op != wsAck {
+			t.Fatalf("want ack, got %+v", resp)
+		}
+		report, err := ps.Publish("source", "alerts", []byte(`1`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Matched == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscribe was acknowledged without a subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp := c.receive(); resp.Op != wsMessage || resp.Topic != "alerts" {
+		t.Fatalf("want message, got %+v", resp)
+	}
+}
+
+func TestWebSocketPublishRelays(t *testing.T) {
+	t.Parallel()
+
+	relayed := make(chan string, 1)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relayed <- r.URL.Path
+	}))
+	t.Cleanup(remote.Close)
+	ps := pubsub.New()
+	ht := NewHTTP(func(ht *HTTP) {
+		ht.PubSub = ps
+		ht.Servers = staticServers{remote.URL}
+		ht.Log = log.New(io.Discard, "", 0)
+	})
+	ws := NewWebSocket(func(ws *WebSocket) {
+		ws.PubSub = ps
+		ws.Relay = ht
+		ws.Log = log.New(io.Discard, "", 0)
+	})
+	server := httptest.NewServer(ws)
+	t.Cleanup(server.Close)
+
+	c := dialWebSocket(t, server)
+	c.send(wsRequest{Op: wsPublish, Ref: "1", Message: &pubsub.Data{Topic: "orders.eu", Source: "shop", Data: []byte(`1`)}})
+	if resp := c.receive(); resp.Op != wsAck {
+		t.Fatalf("want ack of publish, got %+v", resp)
+	}
+	select {
+	case path := <-relayed:
+		if path != "/orders.eu/shop" {
+			t.Fatalf("unexpected relay to %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not relayed")
+	}
+}
+
+// waitForUnsubscribe waits until topic has no subscribers left
+func waitForUnsubscribe(t *testing.T, ps *pubsub.PubSub, topic string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		report, err := ps.Publish("probe", topic, []byte(`0`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Matched == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still has subscribers", topic)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketFragments(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	c := dialWebSocket(t, newWebSocketServer(t, ps))
+	req := `{"op":"subscribe","ref":"1","topic":"topic"}`
+	c.writeFrame(opText, []byte(req[:10]))
+	c.writeFrame(0x80|opPing, nil)
+	c.writeFrame(0x80|opContinuation, []byte(req[10:]))
+	if op, _ := c.readFrame(); op != opPong {
+		t.Fatalf("want pong between fragments, got opcode %d", op)
+	}
+	if resp := c.receive(); resp.Op != wsAck || resp.Ref != "1" {
+		t.Fatalf("want ack of fragmented request, got %+v", resp)
+	}
+}
+
+func TestWebSocketClientGone(t *testing.T) {
+	t.Parallel()
+
+	ps := pubsub.New()
+	c := dialWebSocket(t, newWebSocketServer(t, ps))
+	c.send(wsRequest{Op: wsSubscribe, Ref: "1", Topic: "topic"})
+	if resp := c.receive(); resp.Op != wsAck {
+		t.Fatalf("want ack, got %+v", resp)
+	}
+
+	// the client stops sending without a close frame
+	if err := c.conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("want server to close the connection, got %v", err)
+	}
+	waitForUnsubscribe(t, ps, "topic")
+}
+
+func TestWebSocketCloseCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		write func(c *wsClient)
+		want  int
+	}{
+		{"binary", func(c *wsClient) { c.writeFrame(0x80|opBinary, []byte("{}")) }, closeUnsupportedData},
+		{"too large", func(c *wsClient) { c.writeFrame(0x80|opText, make([]byte, 2048)) }, closeMessageTooBig},
+		{"invalid utf-8", func(c *wsClient) { c.writeFrame(0x80|opText, []byte{0xff}) }, closeInvalidPayload},
+		{"reserved bits", func(c *wsClient) { c.writeFrame(0xc0|opText, []byte("{}")) }, closeProtocolError},
+		{"fragmented ping", func(c *wsClient) { c.writeFrame(opPing, nil) }, closeProtocolError},
+		{"unmasked", func(c *wsClient) {
+			if _, err := c.conn.Write([]byte{0x80 | opText, 2, '{', '}'}); err != nil {
+				t.Fatal(err)
+			}
+		}, closeProtocolError},
+	}
+	server := newWebSocketServer(t, pubsub.New())
+	for _, tt := range tests {
+		c := dialWebSocket(t, server)
+		tt.write(c)
+		if code := c.closeCode(); code != tt.want {
+			t.Errorf("%s: want close code %d, got %d", tt.name, tt.want, code)
+		}
+	}
+}
+
+func TestWebSocketHugeFrameWithoutLimit(t *testing.T) {
+	t.Parallel()
+
+	ws := NewWebSocket(func(ws *WebSocket) {
+		ws.Log = log.New(io.Discard, "", 0)
+		ws.MaxMessageSize = 0
+	})
+	server := httptest.NewServer(ws)
+	t.Cleanup(server.Close)
+	c := dialWebSocket(t, server)
+
+	// a header announcing a terabyte frame
+	frame := binary.BigEndian.AppendUint64([]byte{0x80 | opText, 0x80 | 127}, 1<<40)
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if code := c.closeCode(); code != closeMessageTooBig {
+		t.Fatalf("want close code %d, got %d", closeMessageTooBig, code)
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	t.Parallel()
+
+	ws := NewWebSocket(func(ws *WebSocket) {
+		ws.Log = log.New(io.Discard, "", 0)
+	})
+	tests := []struct {
+		name    string
+		version string
+		key     string
+		want    int
+	}{
+		{"plain request", "", "", http.StatusBadRequest},
+		{"old version", "8", "dGhlIHNhbXBsZSBub25jZQ==", http.StatusUpgradeRequired},
+		{"short key", "13", "c2hvcnQ=", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if tt.version != "" {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Sec-WebSocket-Version", tt.version)
+			r.Header.Set("Sec-WebSocket-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		ws.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: want status %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+	if acceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("accept key does not match RFC 6455 example")
+	}
+}
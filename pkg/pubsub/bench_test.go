@@ -0,0 +1,115 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const benchTopics = 5000
+
+func newBenchPubSub(b *testing.B) (*PubSub, []string) {
+	b.Helper()
+
+	ps := New(func(ps *PubSub) {
+		ps.MaxTopics = 2 * benchTopics
+		ps.MaxSubscribers = 1 << 20
+	})
+	topics := make([]string, benchTopics)
+	for i := range topics {
+		topics[i] = fmt.Sprintf("tenant%d.orders.%d", i%50, i)
+		if _, err := ps.Subscribe("listener", topics[i], func(o *SubscribeOptions) {
+			o.Overflow = DropOldest
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := ps.Subscribe("audit", fmt.Sprintf("tenant%d.>", i), func(o *SubscribeOptions) {
+			o.Overflow = DropOldest
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return ps, topics
+}
+
+func BenchmarkPublishManyTopics(b *testing.B) {
+	ps, topics := newBenchPubSub(b)
+	var next atomic.Uint64
+	data := []byte(`{"total":1}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			topic := topics[next.Add(1)%benchTopics]
+			if _, err := ps.Publish("bench", topic, data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkSubscribeChurn(b *testing.B) {
+	ps, topics := newBenchPubSub(b)
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1)
+			id, topic := strconv.FormatUint(n, 10), topics[n%benchTopics]
+			if _, err := ps.Subscribe(id, topic, func(o *SubscribeOptions) {
+				o.Overflow = DropNewest
+			}); err != nil {
+				b.Error(err)
+				return
+			}
+			ps.Unsubscribe(id, topic)
+		}
+	})
+}
+
+func BenchmarkPublishDuringChurn(b *testing.B) {
+	ps, topics := newBenchPubSub(b)
+	var (
+		next atomic.Uint64
+		stop atomic.Bool
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		wg.Go(func() {
+			for i := w; !stop.Load(); i += 4 {
+				id, topic := "churn"+strconv.Itoa(w), fmt.Sprintf("churn.%d", i%benchTopics)
+				if _, err := ps.Subscribe(id, topic, func(o *SubscribeOptions) {
+					o.Overflow = DropNewest
+				}); err != nil {
+					b.Error(err)
+					return
+				}
+				ps.Unsubscribe(id, topic)
+			}
+		})
+	}
+	data := []byte(`{"total":1}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			topic := topics[next.Add(1)%benchTopics]
+			if _, err := ps.Publish("bench", topic, data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	stop.Store(true)
+	wg.Wait()
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts values of a Typed PubSub to message payloads
+type Codec[T any] interface {
+	// ContentType is the media type of encoded payloads.
+	ContentType() string
+	Encode(v T) ([]byte, error)
+	Decode(body []byte) (T, error)
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec[T any] struct{}
+
+// ContentType implements Codec
+func (JSONCodec[T]) ContentType() string { return "application/json" }
+
+// Encode implements Codec
+func (JSONCodec[T]) Encode(v T) ([]byte, error) { return json.Marshal(v) }
+
+// Decode implements Codec
+func (JSONCodec[T]) Decode(body []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(body, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob. Payloads are binary and
+// delivered base64 encoded to non-Go subscribers.
+type GobCodec[T any] struct{}
+
+// ContentType implements Codec
+func (GobCodec[T]) ContentType() string { return "application/x-gob" }
+
+// Encode implements Codec
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec
+func (GobCodec[T]) Decode(body []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(body)).Decode(&v)
+	return v, err
+}
+
+// RawCodec passes payloads through as bytes of Type, which defaults to
+// application/octet-stream
+type RawCodec struct {
+	Type string
+}
+
+// ContentType implements Codec
+func (c RawCodec) ContentType() string {
+	if c.Type == "" {
+		return "application/octet-stream"
+	}
+	return c.Type
+}
+
+// Encode implements Codec
+func (RawCodec) Encode(v []byte) ([]byte, error) { return v, nil }
+
+// Decode implements Codec
+func (RawCodec) Decode(body []byte) ([]byte, error) { return body, nil }
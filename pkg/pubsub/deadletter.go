@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	"maps"
+	"strings"
+	"time"
+)
+
+// Dead letters of a topic are published to DeadLetterPrefix followed by
+// the topic, with the failure in message headers. All dead letters are
+// matched by $dlq.>
+const (
+	DeadLetterPrefix           = reservedPrefix + "dlq" + separator
+	HeaderDeadLetterReason     = "Dead-Letter-Reason"
+	HeaderDeadLetterSubscriber = "Dead-Letter-Subscriber"
+)
+
+// Reasons of messages lost by subscribers
+const (
+	ReasonQueueFull    = "queue full"
+	ReasonEvicted      = "evicted from full queue"
+	ReasonDisconnected = "slow subscriber disconnected"
+)
+
+// lostMessage is a message a subscriber failed to receive
+type lostMessage struct {
+	msg    Data
+	reason string
+}
+
+// DeadLetter publishes msg lost by subscriber id to the dead letter topic
+// of its topic when PubSub.DeadLetters is enabled. Dead letters are not
+// dead lettered again.
+func (ps *PubSub) DeadLetter(id string, msg Data, reason string) {
+	if !ps.DeadLetters || strings.HasPrefix(msg.Topic, DeadLetterPrefix) {
+		return
+	}
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = make(map[string]string, 2)
+	}
+	headers[HeaderDeadLetterReason] = reason
+	headers[HeaderDeadLetterSubscriber] = id
+	msg.Headers = headers
+	msg.Topic = DeadLetterPrefix + msg.Topic
+	msg.Seq = 0
+	msg.Retain = false
+	msg.ExpiresAt = time.Time{}
+	msg.DeliverAt = time.Time{}
+	// a dead letter failing to store is lost like the message itself
+	if _, err := ps.dispatch(msg); err != nil {
+		ps.Log.Printf("Error publishing dead letter to %q: %v", msg.Topic, err)
+	}
+}
+
+func (ps *PubSub) deadLetters(id string, lost []lostMessage) {
+	for _, l := range lost {
+		ps.DeadLetter(id, l.msg, l.reason)
+	}
+}
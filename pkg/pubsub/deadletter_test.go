@@ -0,0 +1,84 @@
+package pubsub
+
+import "testing"
+
+func TestDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.DeadLetters = true })
+	subscribe := func(id, topic string, size int, overflow OverflowPolicy) DataChannel {
+		ch, err := ps.Subscribe(id, topic, func(o *SubscribeOptions) {
+			o.BufferSize = size
+			o.Overflow = overflow
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+	dlq := subscribe("operator", DeadLetterPrefix+"orders", 1, DropNewest)
+	all := subscribe("all", ">", 2, DropNewest)
+	every := subscribe("every", DeadLetterPrefix+">", 2, DropNewest)
+	subscribe("newest", "orders", 1, DropNewest)
+	subscribe("slow", "orders", 1, Disconnect)
+
+	for _, body := range []string{`1`, `2`} {
+		if _, err := ps.Publish("source", "orders", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dlq holds a single message, the other dead letter is dropped and
+	// not dead lettered again
+	got := <-dlq
+	if got.Topic != DeadLetterPrefix+"orders" || string(got.Data) != `2` || got.Headers[HeaderDeadLetterReason] == "" {
+		t.Fatalf("unexpected dead letter: %+v", got)
+	}
+	if stats, _ := ps.Stats("operator", DeadLetterPrefix+"orders"); stats.Dropped != 1 {
+		t.Fatalf("want 2 dead letters with one dropped, got %+v", stats)
+	}
+	if len(every) != 2 {
+		t.Fatalf("want both dead letters matched by %s>, got %d", DeadLetterPrefix, len(every))
+	}
+	if len(all) != 2 {
+		t.Fatalf("wildcard subscriber should get only the 2 published messages, got %d", len(all))
+	}
+	if _, ok := ps.Stats("slow", "orders"); ok {
+		t.Fatal("slow subscriber should be disconnected")
+	}
+}
+
+func TestDeadLetterReasons(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.DeadLetters = true })
+	dlq, err := ps.Subscribe("operator", DeadLetterPrefix+"topic", func(o *SubscribeOptions) {
+		o.BufferSize = 4
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe("oldest", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 1
+		o.Overflow = DropOldest
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`1`, `2`} {
+		if _, err := ps.Publish("source", "topic", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps.DeadLetter("http", Data{Topic: "topic", Data: []byte(`3`)}, "delivery failed")
+
+	for _, want := range []struct{ body, reason, id string }{
+		{`1`, ReasonEvicted, "oldest"},
+		{`3`, "delivery failed", "http"},
+	} {
+		got := <-dlq
+		if string(got.Data) != want.body || got.Headers[HeaderDeadLetterReason] != want.reason || got.Headers[HeaderDeadLetterSubscriber] != want.id {
+			t.Fatalf("unexpected dead letter: %+v", got)
+		}
+	}
+}
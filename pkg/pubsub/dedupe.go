@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupe remembers keys of recently published messages. Keys are spread
+// over shards so publishers of different topics rarely contend.
+type dedupe struct {
+	shards [lockShards]dedupeShard
+}
+
+type dedupeShard struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []dedupeEntry // keys by time they were added, from head
+	head  int
+}
+
+type dedupeEntry struct {
+	key string
+	at  time.Time
+}
+
+// dedupeKey identifies a message for deduplication
+func dedupeKey(message Data) string {
+	return message.Topic + "\x00" + message.ID
+}
+
+// add records key and reports whether it was already seen within window.
+// Each shard keeps at most size/lockShards keys, evicting the oldest.
+func (d *dedupe) add(key string, now time.Time, window time.Duration, size int) bool {
+	s := &d.shards[hash(key)%lockShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := max(size/lockShards, 1)
+	for s.head < len(s.order) {
+		oldest := s.order[s.head]
+		if len(s.order)-s.head < limit && now.Sub(oldest.at) < window {
+			break
+		}
+		if at, ok := s.seen[oldest.key]; ok && at.Equal(oldest.at) {
+			delete(s.seen, oldest.key)
+		}
+		s.order[s.head] = dedupeEntry{}
+		s.head++
+	}
+	if s.head > len(s.order)/2 {
+		s.order = append(s.order[:0], s.order[s.head:]...)
+		s.head = 0
+	}
+
+	if at, ok := s.seen[key]; ok && now.Sub(at) < window {
+		return true
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]time.Time)
+	}
+	s.seen[key] = now
+	s.order = append(s.order, dedupeEntry{key: key, at: now})
+	return false
+}
+
+// forget drops key so the message can be published again
+func (d *dedupe) forget(key string) {
+	s := &d.shards[hash(key)%lockShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seen, key)
+}
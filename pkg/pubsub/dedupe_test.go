@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDedupeWindowAndSize(t *testing.T) {
+	t.Parallel()
+
+	var d dedupe
+	now := time.Now()
+	if d.add("a", now, time.Minute, 1000) {
+		t.Fatal("first key reported as duplicate")
+	}
+	if !d.add("a", now.Add(time.Second), time.Minute, 1000) {
+		t.Fatal("key within window not reported as duplicate")
+	}
+	if d.add("a", now.Add(2*time.Minute), time.Minute, 1000) {
+		t.Fatal("key past window reported as duplicate")
+	}
+
+	// each shard keeps one key when size is below the number of shards
+	var small dedupe
+	for i := 0; i < 4*lockShards; i++ {
+		small.add(strconv.Itoa(i), now, time.Hour, 1)
+	}
+	remembered := 0
+	for i := 0; i < 4*lockShards; i++ {
+		if small.add(strconv.Itoa(i), now, time.Hour, 1<<20) {
+			remembered++
+		}
+	}
+	if remembered == 0 || remembered > lockShards {
+		t.Fatalf("want at most %d remembered keys, got %d", lockShards, remembered)
+	}
+}
+
+func TestPublishDropsDuplicates(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.MaxScheduled = 1 })
+	ch, err := ps.Subscribe("id", ">", func(o *SubscribeOptions) {
+		o.BufferSize = 4
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []Data{
+		{Topic: "a", ID: "1", Data: []byte(`1`)},
+		{Topic: "a", ID: "1", Data: []byte(`1`)},
+		{Topic: "b", ID: "1", Data: []byte(`1`)},
+	} {
+		if _, err := ps.PublishData(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ch) != 2 {
+		t.Fatalf("want 2 messages, got %d", len(ch))
+	}
+	report, err := ps.PublishData(Data{Topic: "a", ID: "1", Data: []byte(`1`)})
+	if err != nil || !report.Duplicate || report.Matched != 0 {
+		t.Fatalf("unexpected report: %+v, %v", report, err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if _, err := ps.PublishData(Data{Topic: "c", ID: "1", Data: []byte(`1`), DeliverAt: later}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(Data{Topic: "d", ID: "1", Data: []byte(`1`), DeliverAt: later}); !errors.Is(err, ErrTooManyScheduled) {
+		t.Fatalf("want ErrTooManyScheduled, got %v", err)
+	}
+	if report, err := ps.PublishData(Data{Topic: "d", ID: "1", Data: []byte(`1`)}); err != nil || report.Duplicate {
+		t.Fatalf("failed publish should not be remembered: %+v, %v", report, err)
+	}
+}
+
+func TestPublishRemembersOnlyGivenIDs(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	for range 10 {
+		if _, err := ps.Publish("source", "topic", []byte(`1`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ps.PublishData(Data{Topic: "topic", ID: "key", Data: []byte(`1`)}); err != nil {
+		t.Fatal(err)
+	}
+	remembered := 0
+	for i := range ps.seen.shards {
+		remembered += len(ps.seen.shards[i].seen)
+	}
+	if remembered != 1 {
+		t.Fatalf("want only the given ID remembered, got %d keys", remembered)
+	}
+}
@@ -0,0 +1,489 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidFilter is returned for filter expressions that do not compile
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter is a compiled filter expression. Expressions compare message
+// fields with literals and combine the results, e.g.
+//
+//	headers.type == "order" and data.total >= 100
+//	data.region in ("eu", "us") or not exists(headers.test)
+//
+// Fields are headers.<name> or data.<path> where path names nested
+// object keys or array indexes of a JSON payload. Literals are strings,
+// numbers, true, false and null. Comparisons of a missing field or of
+// values of different types are false; header values compared with
+// numbers are parsed as numbers.
+type Filter struct {
+	expr string
+	root filterExpr
+}
+
+// CompileFilter parses a filter expression
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{lex: filterLexer{input: expr}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String returns the source expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether msg passes the filter
+func (f *Filter) Match(msg Data) bool {
+	return f.root.eval(&filterInput{msg: msg})
+}
+
+// filterInput is a message being filtered. The JSON payload is decoded
+// at most once and shared by all filters evaluated against the message.
+type filterInput struct {
+	msg     Data
+	body    any
+	decoded bool
+}
+
+func (in *filterInput) payload() any {
+	if !in.decoded {
+		in.decoded = true
+		// invalid payloads match no data fields
+		if in.msg.Encoding == "" && json.Unmarshal(in.msg.Data, &in.body) != nil {
+			in.body = nil
+		}
+	}
+	return in.body
+}
+
+type filterField struct {
+	header bool
+	path   []string
+}
+
+func (f filterField) lookup(in *filterInput) (any, bool) {
+	if f.header {
+		name := f.path[0]
+		if value, ok := in.msg.Headers[name]; ok {
+			return value, true
+		}
+		for key, value := range in.msg.Headers {
+			if strings.EqualFold(key, name) {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+
+	value := in.payload()
+	for _, key := range f.path {
+		switch v := value.(type) {
+		case map[string]any:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+type filterExpr interface {
+	eval(in *filterInput) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+
+func (e andExpr) eval(in *filterInput) bool { return e.left.eval(in) && e.right.eval(in) }
+
+type orExpr struct{ left, right filterExpr }
+
+func (e orExpr) eval(in *filterInput) bool { return e.left.eval(in) || e.right.eval(in) }
+
+type notExpr struct{ expr filterExpr }
+
+func (e notExpr) eval(in *filterInput) bool { return !e.expr.eval(in) }
+
+type existsExpr struct{ field filterField }
+
+func (e existsExpr) eval(in *filterInput) bool {
+	_, ok := e.field.lookup(in)
+	return ok
+}
+
+type compareExpr struct {
+	field filterField
+	op    string
+	value any
+}
+
+func (e compareExpr) eval(in *filterInput) bool {
+	value, ok := e.field.lookup(in)
+	if !ok {
+		return false
+	}
+	return compare(value, e.op, e.value)
+}
+
+type inExpr struct {
+	field  filterField
+	values []any
+}
+
+func (e inExpr) eval(in *filterInput) bool {
+	value, ok := e.field.lookup(in)
+	if !ok {
+		return false
+	}
+	for _, v := range e.values {
+		if compare(value, "==", v) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to a field value and a literal
+func compare(value any, op string, literal any) bool {
+	if s, ok := value.(string); ok {
+		if _, ok := literal.(float64); ok {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return false
+			}
+			value = n
+		}
+	}
+
+	switch a := value.(type) {
+	case float64:
+		b, ok := literal.(float64)
+		if !ok {
+			return false
+		}
+		return ordered(a, op, b)
+	case string:
+		b, ok := literal.(string)
+		if !ok {
+			return false
+		}
+		return ordered(a, op, b)
+	case bool, nil:
+		if _, ok := literal.(bool); !ok && literal != nil {
+			return false
+		}
+		switch op {
+		case "==":
+			return value == literal
+		case "!=":
+			return value != literal
+		}
+	}
+	return false
+}
+
+func ordered[T float64 | string](a T, op string, b T) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	default:
+		return false
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+	tokenError
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type filterLexer struct {
+	input string
+	pos   int
+}
+
+func (l *filterLexer) next() filterToken {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return filterToken{kind: tokenEOF, pos: start}
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '(' || c == ')' || c == ',':
+		l.pos++
+		return filterToken{kind: tokenPunct, text: string(c), pos: start}
+	case c == '=' || c == '!' || c == '<' || c == '>':
+		l.pos++
+		if l.pos < len(l.input) && l.input[l.pos] == '=' {
+			l.pos++
+		}
+		op := l.input[start:l.pos]
+		if op == "=" || op == "!" {
+			return filterToken{kind: tokenError, text: op, pos: start}
+		}
+		return filterToken{kind: tokenOperator, text: op, pos: start}
+	case c == '"' || c == '\'':
+		return l.string(c)
+	case c == '-' || c >= '0' && c <= '9':
+		l.pos++
+		for l.pos < len(l.input) && strings.IndexByte("0123456789.eE+-", l.input[l.pos]) >= 0 {
+			l.pos++
+		}
+		return filterToken{kind: tokenNumber, text: l.input[start:l.pos], pos: start}
+	case isIdentRune(rune(c)):
+		for l.pos < len(l.input) && isIdentRune(rune(l.input[l.pos])) {
+			l.pos++
+		}
+		return filterToken{kind: tokenIdent, text: l.input[start:l.pos], pos: start}
+	default:
+		l.pos++
+		return filterToken{kind: tokenError, text: string(c), pos: start}
+	}
+}
+
+// string lexes a quoted string, a backslash escapes the next character
+func (l *filterLexer) string(quote byte) filterToken {
+	start := l.pos
+	var b strings.Builder
+	for l.pos++; l.pos < len(l.input); l.pos++ {
+		c := l.input[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.input):
+			l.pos++
+			b.WriteByte(l.input[l.pos])
+		case c == quote:
+			l.pos++
+			return filterToken{kind: tokenString, text: b.String(), pos: start}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return filterToken{kind: tokenError, text: l.input[start:], pos: start}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '.' || r == '-' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// filterParser is a recursive descent parser of the grammar
+//
+//	or      = and { "or" and }
+//	and     = unary { "and" unary }
+//	unary   = "not" unary | primary
+//	primary = "(" or ")" | "exists" "(" field ")"
+//	        | field op literal | field "in" "(" literal { "," literal } ")"
+type filterParser struct {
+	lex filterLexer
+	tok filterToken
+}
+
+func (p *filterParser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrInvalidFilter, fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+func (p *filterParser) keyword(word string) bool {
+	return p.tok.kind == tokenIdent && p.tok.text == word
+}
+
+func (p *filterParser) expect(punct string) error {
+	if p.tok.kind != tokenPunct || p.tok.text != punct {
+		return p.errorf("expected %q", punct)
+	}
+	p.next()
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.keyword("not") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.tok.kind == tokenPunct && p.tok.text == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	if p.keyword("exists") {
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		return existsExpr{field}, p.expect(")")
+	}
+
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("in") {
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var values []any
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if p.tok.kind != tokenPunct || p.tok.text != "," {
+				break
+			}
+			p.next()
+		}
+		return inExpr{field, values}, p.expect(")")
+	}
+	if p.tok.kind != tokenOperator {
+		return nil, p.errorf("expected comparison operator")
+	}
+	op := p.tok.text
+	p.next()
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return compareExpr{field, op, value}, nil
+}
+
+func (p *filterParser) parseField() (filterField, error) {
+	if p.tok.kind != tokenIdent {
+		return filterField{}, p.errorf("expected field")
+	}
+	scope, path, _ := strings.Cut(p.tok.text, separator)
+	var field filterField
+	switch {
+	case scope == "headers" && path != "":
+		field = filterField{header: true, path: []string{path}}
+	case scope == "data" && path != "":
+		field = filterField{path: strings.Split(path, separator)}
+	default:
+		return filterField{}, p.errorf("unknown field %q", p.tok.text)
+	}
+	p.next()
+	return field, nil
+}
+
+func (p *filterParser) parseLiteral() (any, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokenString:
+		p.next()
+		return tok.text, nil
+	case tok.kind == tokenNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		p.next()
+		return n, nil
+	case p.keyword("true"), p.keyword("false"):
+		p.next()
+		return tok.text == "true", nil
+	case p.keyword("null"):
+		p.next()
+		return nil, nil
+	default:
+		return nil, p.errorf("expected literal")
+	}
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	msg := Data{
+		Data:    []byte(`{"total": 120, "region": "eu", "paid": true, "items": [{"sku": "a1"}], "note": null}`),
+		Headers: map[string]string{"Type": "order", "Priority": "5"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`headers.Type == "order"`, true},
+		{`headers.type == 'order'`, true},
+		{`headers.Type != "order"`, false},
+		{`headers.Priority > 3`, true},
+		{`headers.Priority >= "6"`, false},
+		{`data.total >= 100 and data.region == "eu"`, true},
+		{`data.total < 100 or data.paid == true`, true},
+		{`not data.paid == true`, false},
+		{`data.region in ("us", "eu")`, true},
+		{`data.region in ("us")`, false},
+		{`data.items.0.sku == "a1"`, true},
+		{`exists(data.note)`, true},
+		{`exists(headers.Trace)`, false},
+		{`not exists(data.missing) and (data.total == 1 or data.total == 120)`, true},
+		{`data.missing != 1`, false},
+		{`data.total == "120"`, false},
+		{`data.note == null`, true},
+	}
+
+	for _, tt := range tests {
+		f, err := CompileFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got := f.Match(msg); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{
+		``,
+		`total > 1`,
+		`data.total >`,
+		`data.total = 1`,
+		`data.total == 1 and`,
+		`(data.total == 1`,
+		`data.region in ()`,
+		`data.region == "eu`,
+		`exists data.total`,
+		`data.total == 1 data.total == 2`,
+	} {
+		if _, err := CompileFilter(expr); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%q: want ErrInvalidFilter, got %v", expr, err)
+		}
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	ch, err := ps.Subscribe("id", "orders.>", func(o *SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = DropNewest
+		o.Filter = `data.total > 100`
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"total": 10}`, `{"total": 200}`, `"text"`} {
+		if _, err := ps.Publish("source", "orders.created", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := <-ch; string(got.Data) != `{"total": 200}` {
+		t.Fatalf("unexpected message: %s", got.Data)
+	}
+	if stats, _ := ps.Stats("id", "orders.>"); stats.Delivered != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := ps.Subscribe("other", "orders.>", func(o *SubscribeOptions) { o.Filter = "total" }); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("want ErrInvalidFilter, got %v", err)
+	}
+}
+
+func TestGroupSkipsFilteredMembers(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	subscribe := func(id, filter string) DataChannel {
+		ch, err := ps.Subscribe(id, "topic", func(o *SubscribeOptions) {
+			o.BufferSize = 4
+			o.Overflow = DropNewest
+			o.Group = "workers"
+			o.Filter = filter
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+	eu := subscribe("eu", `headers.region == "eu"`)
+	us := subscribe("us", `headers.region == "us"`)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`1`), Headers: map[string]string{"region": "eu"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`1`), Headers: map[string]string{"region": "asia"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eu) != 2 || len(us) != 0 {
+		t.Fatalf("want 2 messages for eu and none for us, got %d and %d", len(eu), len(us))
+	}
+}
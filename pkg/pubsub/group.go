@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+)
+
+// Balance selects which member of a consumer group receives a message
+type Balance int
+
+const (
+	// RoundRobin hands messages to group members in turn.
+	RoundRobin Balance = iota
+	// LeastLoaded hands messages to the member with the shortest queue.
+	LeastLoaded
+)
+
+// group is a set of subscriptions sharing messages of one topic pattern
+type group struct {
+	members []*subscription
+	next    atomic.Uint64
+}
+
+// pick returns the member receiving the message or nil when no member
+// accepts it
+func (g *group) pick(balance Balance, in *filterInput) *subscription {
+	start := int(g.next.Add(1) % uint64(len(g.members))) // #nosec G115 -- modulo of a slice length
+	var best *subscription
+	for i := 0; i < len(g.members); i++ {
+		member := g.members[(start+i)%len(g.members)]
+		if !member.accepts(in) {
+			continue
+		}
+		if balance != LeastLoaded {
+			return member
+		}
+		if best == nil || len(member.ch) < len(best.ch) {
+			best = member
+		}
+		if len(best.ch) == 0 {
+			break
+		}
+	}
+	return best
+}
+
+// topicSubs holds subscriptions of one topic pattern. Subscriptions
+// without a group receive every message, each group receives it once.
+// It is immutable once stored in the trie, changes make a copy.
+type topicSubs struct {
+	subs   map[string]*subscription
+	groups map[string]*group
+}
+
+func newTopicSubs() *topicSubs {
+	return &topicSubs{
+		subs:   make(map[string]*subscription),
+		groups: make(map[string]*group),
+	}
+}
+
+// with returns a copy of ts including sub
+func (ts *topicSubs) with(sub *subscription) *topicSubs {
+	out := &topicSubs{subs: maps.Clone(ts.subs), groups: maps.Clone(ts.groups)}
+	out.subs[sub.id] = sub
+	if sub.group == "" {
+		return out
+	}
+	g := &group{}
+	if old, ok := ts.groups[sub.group]; ok {
+		g.members = slices.Clip(old.members)
+		g.next.Store(old.next.Load())
+	}
+	g.members = append(g.members, sub)
+	out.groups[sub.group] = g
+	return out
+}
+
+// without returns a copy of ts excluding sub
+func (ts *topicSubs) without(sub *subscription) *topicSubs {
+	out := &topicSubs{subs: maps.Clone(ts.subs), groups: maps.Clone(ts.groups)}
+	delete(out.subs, sub.id)
+	old, ok := ts.groups[sub.group]
+	if sub.group == "" || !ok {
+		return out
+	}
+	g := &group{}
+	g.next.Store(old.next.Load())
+	for _, member := range old.members {
+		if member != sub {
+			g.members = append(g.members, member)
+		}
+	}
+	if len(g.members) == 0 {
+		delete(out.groups, sub.group)
+	} else {
+		out.groups[sub.group] = g
+	}
+	return out
+}
+
+// collect appends subscriptions that should receive the message
+func (ts *topicSubs) collect(out []*subscription, balance Balance, in *filterInput) []*subscription {
+	for _, sub := range ts.subs {
+		if sub.group == "" && sub.accepts(in) {
+			out = append(out, sub)
+		}
+	}
+	for _, g := range ts.groups {
+		if sub := g.pick(balance, in); sub != nil {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
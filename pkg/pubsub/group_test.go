@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+)
+
+func subscribeGroup(t *testing.T, ps *PubSub, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		if _, err := ps.Subscribe(id, "topic", func(o *SubscribeOptions) {
+			o.BufferSize = 100
+			o.Group = "workers"
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func queued(t *testing.T, ps *PubSub, id string) int {
+	t.Helper()
+
+	stats, ok := ps.Stats(id, "topic")
+	if !ok {
+		t.Fatalf("missing subscription %s", id)
+	}
+	return stats.Queued
+}
+
+func TestGroupDeliversOnce(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	subscribeGroup(t, ps, "a", "b", "c")
+	if _, err := ps.Subscribe("audit", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 100
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 30; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := queued(t, ps, "audit"); got != 30 {
+		t.Fatalf("want 30 messages outside of group, got %d", got)
+	}
+	var total int
+	for _, id := range []string{"a", "b", "c"} {
+		got := queued(t, ps, id)
+		if got != 10 {
+			t.Errorf("want 10 messages for %s, got %d", id, got)
+		}
+		total += got
+	}
+	if total != 30 {
+		t.Fatalf("want 30 messages across group, got %d", total)
+	}
+}
+
+func TestGroupLeastLoaded(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.GroupBalance = LeastLoaded
+	})
+	subscribeGroup(t, ps, "busy")
+	for i := 0; i < 5; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribeGroup(t, ps, "idle")
+	for i := 0; i < 5; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := queued(t, ps, "busy"); got != 5 {
+		t.Fatalf("want busy member to keep 5 messages, got %d", got)
+	}
+	if got := queued(t, ps, "idle"); got != 5 {
+		t.Fatalf("want idle member to get 5 messages, got %d", got)
+	}
+}
+
+func TestGroupMemberLeaves(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	subscribeGroup(t, ps, "a", "b")
+	ps.Unsubscribe("a", "topic")
+
+	for i := 0; i < 4; i++ {
+		if _, err := ps.Publish("source", "topic", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := queued(t, ps, "b"); got != 4 {
+		t.Fatalf("want remaining member to get 4 messages, got %d", got)
+	}
+
+	ps.Unsubscribe("b", "topic")
+	if got := ps.topics.Load(); got != 0 {
+		t.Fatalf("expected no topics, got %d", got)
+	}
+}
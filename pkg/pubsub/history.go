@@ -0,0 +1,61 @@
+package pubsub
+
+import "time"
+
+type entry struct {
+	data Data
+	at   time.Time
+}
+
+// history is a bounded ring buffer of messages published to one topic.
+// It also owns the topic sequence so numbers keep growing after old
+// messages are evicted.
+type history struct {
+	seq   uint64
+	buf   []entry
+	start int
+	n     int
+}
+
+func newHistory(size int) *history {
+	return &history{buf: make([]entry, size)}
+}
+
+// append assigns the next sequence number to data and stores it,
+// overwriting the oldest message when the buffer is full
+func (h *history) append(data Data, now time.Time) Data {
+	h.seq++
+	data.Seq = h.seq
+	end := (h.start + h.n) % len(h.buf)
+	h.buf[end] = entry{data: data, at: now}
+	if h.n < len(h.buf) {
+		h.n++
+	} else {
+		h.start = (h.start + 1) % len(h.buf)
+	}
+	return data
+}
+
+// evict drops messages older than maxAge
+func (h *history) evict(now time.Time, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	for h.n > 0 && now.Sub(h.buf[h.start].at) > maxAge {
+		h.buf[h.start] = entry{}
+		h.start = (h.start + 1) % len(h.buf)
+		h.n--
+	}
+}
+
+// since returns retained messages with sequence numbers greater than seq
+func (h *history) since(seq uint64) []Data {
+	var out []Data
+	for i := 0; i < h.n; i++ {
+		e := h.buf[(h.start+i)%len(h.buf)]
+		if e.data.Seq > seq {
+			out = append(out, e.data)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryKeepsNewestMessages(t *testing.T) {
+	t.Parallel()
+
+	h := newHistory(3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		h.append(Data{Topic: "topic"}, now)
+	}
+
+	got := h.since(0)
+	if len(got) != 3 {
+		t.Fatalf("want 3 messages, got %d", len(got))
+	}
+	for i, want := range []uint64{3, 4, 5} {
+		if got[i].Seq != want {
+			t.Errorf("want seq %d, got %d", want, got[i].Seq)
+		}
+	}
+	if got := h.since(4); len(got) != 1 || got[0].Seq != 5 {
+		t.Fatalf("unexpected messages since 4: %+v", got)
+	}
+}
+
+func TestHistoryEvictsOldMessages(t *testing.T) {
+	t.Parallel()
+
+	h := newHistory(10)
+	now := time.Now()
+	h.append(Data{}, now.Add(-time.Minute))
+	h.append(Data{}, now.Add(-time.Second))
+	h.append(Data{}, now)
+
+	h.evict(now, 30*time.Second)
+
+	got := h.since(0)
+	if len(got) != 2 || got[0].Seq != 2 {
+		t.Fatalf("unexpected messages: %+v", got)
+	}
+	if msg := h.append(Data{}, now); msg.Seq != 4 {
+		t.Fatalf("want seq 4, got %d", msg.Seq)
+	}
+}
+
+func TestLastSeq(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := New().LastSeq("topic"); ok {
+		t.Fatal("want no sequence without history")
+	}
+	ps := New(func(ps *PubSub) { ps.HistorySize = 1 })
+	for range 3 {
+		if _, err := ps.Publish("source", "topic", []byte(`1`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seq, ok := ps.LastSeq("topic"); !ok || seq != 3 {
+		t.Fatalf("want seq 3, got %d, %t", seq, ok)
+	}
+	if seq, ok := ps.LastSeq("other"); !ok || seq != 0 {
+		t.Fatalf("want seq 0 of a new topic, got %d, %t", seq, ok)
+	}
+}
@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRejected is returned when a publish interceptor rejects a message
+var ErrRejected = errors.New("message rejected")
+
+// PublishInterceptor runs before a message is published. It may modify
+// msg, returning an error rejects the message. Data and Headers may be
+// shared with the publisher, interceptors replace them instead of
+// modifying them in place.
+type PublishInterceptor func(msg *Data) error
+
+// DeliveryInterceptor runs before msg is queued for subscriber id. It
+// returns the message to deliver and false to skip the subscriber.
+// Data and Headers are shared by all subscribers, interceptors replace
+// them instead of modifying them in place.
+type DeliveryInterceptor func(id string, msg Data) (Data, bool)
+
+// Intercept runs interceptors in order on msg. Errors returned by
+// interceptors are wrapped with ErrRejected.
+func Intercept(msg *Data, interceptors []PublishInterceptor) error {
+	for _, intercept := range interceptors {
+		if err := intercept(msg); err != nil {
+			if errors.Is(err, ErrRejected) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	return nil
+}
+
+// interceptDelivery runs interceptors in order until one skips msg
+func interceptDelivery(id string, msg Data, interceptors []DeliveryInterceptor) (Data, bool) {
+	for _, intercept := range interceptors {
+		var ok bool
+		if msg, ok = intercept(id, msg); !ok {
+			return msg, false
+		}
+	}
+	return msg, true
+}
@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestPublishInterceptors(t *testing.T) {
+	t.Parallel()
+
+	errSecret := errors.New("secret topic")
+	var seen []string
+	ps := New(func(ps *PubSub) {
+		ps.PublishInterceptors = []PublishInterceptor{
+			func(msg *Data) error {
+				seen = append(seen, msg.ID)
+				if msg.Topic == "secret" {
+					return errSecret
+				}
+				return nil
+			},
+			func(msg *Data) error {
+				headers := maps.Clone(msg.Headers)
+				if headers == nil {
+					headers = make(map[string]string)
+				}
+				headers["Audited"] = "yes"
+				msg.Headers = headers
+				return nil
+			},
+		}
+	})
+	ch, err := ps.Subscribe("id", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 1
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.Publish("source", "topic", []byte(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ch; got.Headers["Audited"] != "yes" {
+		t.Fatalf("unexpected headers: %v", got.Headers)
+	}
+
+	_, err = ps.Publish("source", "secret", []byte(`1`))
+	if !errors.Is(err, ErrRejected) || !errors.Is(err, errSecret) {
+		t.Fatalf("want ErrRejected wrapping the interceptor error, got %v", err)
+	}
+	if len(seen) != 2 || seen[0] == "" {
+		t.Fatalf("interceptor should see complete messages, got ids %v", seen)
+	}
+}
+
+func TestDeliveryInterceptors(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.DeliveryInterceptors = []DeliveryInterceptor{
+			func(id string, msg Data) (Data, bool) {
+				return msg, string(msg.Data) != `"skip"`
+			},
+		}
+	})
+	redact := func(id string, msg Data) (Data, bool) {
+		msg.Data = []byte(`"redacted"`)
+		return msg, true
+	}
+	subscribe := func(id string, opts ...DeliveryInterceptor) DataChannel {
+		ch, err := ps.Subscribe(id, "topic", func(o *SubscribeOptions) {
+			o.BufferSize = 2
+			o.Overflow = DropNewest
+			o.Interceptors = opts
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+	plain := subscribe("plain")
+	redacted := subscribe("redacted", redact)
+
+	for _, body := range []string{`"skip"`, `"secret"`} {
+		if _, err := ps.Publish("source", "topic", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := <-plain; string(got.Data) != `"secret"` || len(plain) != 0 {
+		t.Fatalf("unexpected message: %s", got.Data)
+	}
+	if got := <-redacted; string(got.Data) != `"redacted"` || len(redacted) != 0 {
+		t.Fatalf("unexpected message: %s", got.Data)
+	}
+}
@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings of non-JSON payloads stored as a JSON string in Data.Data.
+// An empty Data.Encoding means the payload is the JSON value itself.
+const (
+	EncodingText   = "text"
+	EncodingBase64 = "base64"
+)
+
+// ErrInvalidPayload is returned for payloads not matching their declared
+// content type or encoding
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// SetPayload stores body of the given content type in the message. JSON
+// bodies are kept as they are and must be valid, other bodies become a
+// JSON string, base64 encoded unless they are valid UTF-8. Without a
+// content type it is detected from body.
+func (d *Data) SetPayload(contentType string, body []byte) error {
+	if contentType == "" {
+		contentType = detectContentType(body)
+	}
+	d.ContentType = contentType
+	if isJSON(contentType) {
+		if !json.Valid(body) {
+			return ErrInvalidPayload
+		}
+		d.Data = body
+		d.Encoding = ""
+		return nil
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if utf8.Valid(body) {
+		data, err = json.Marshal(string(body))
+		d.Encoding = EncodingText
+	} else {
+		data, err = json.Marshal(base64.StdEncoding.EncodeToString(body))
+		d.Encoding = EncodingBase64
+	}
+	d.Data = data
+	return err
+}
+
+// Payload returns the message body as it was published
+func (d Data) Payload() ([]byte, error) {
+	if d.Encoding == "" {
+		return d.Data, nil
+	}
+	var s string
+	if err := json.Unmarshal(d.Data, &s); err != nil {
+		return nil, ErrInvalidPayload
+	}
+	switch d.Encoding {
+	case EncodingText:
+		return []byte(s), nil
+	case EncodingBase64:
+		body, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, ErrInvalidPayload
+		}
+		return body, nil
+	default:
+		return nil, ErrInvalidPayload
+	}
+}
+
+// payloadSize checks that Data can be encoded and decoded safely and
+// returns the size of the payload as it was published
+func (d Data) payloadSize() (int, error) {
+	if d.Encoding == "" {
+		if !json.Valid(d.Data) {
+			return 0, ErrInvalidPayload
+		}
+		return len(d.Data), nil
+	}
+	body, err := d.Payload()
+	return len(body), err
+}
+
+func detectContentType(body []byte) string {
+	if len(body) > 0 && json.Valid(body) {
+		return "application/json"
+	}
+	return http.DetectContentType(body)
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
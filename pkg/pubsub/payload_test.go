@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSetPayload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		contentType     string
+		body            []byte
+		wantEncoding    string
+		wantContentType string
+		wantErr         bool
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"a":1}`), wantContentType: "application/json"},
+		{name: "json suffix", contentType: "application/cloudevents+json; charset=utf-8", body: []byte(`[1]`), wantContentType: "application/cloudevents+json; charset=utf-8"},
+		{name: "invalid json", contentType: "application/json", body: []byte(`{`), wantErr: true},
+		{name: "text", contentType: "text/plain", body: []byte("hello\nworld"), wantEncoding: EncodingText, wantContentType: "text/plain"},
+		{name: "json looking text", contentType: "text/plain", body: []byte(`123`), wantEncoding: EncodingText, wantContentType: "text/plain"},
+		{name: "binary", contentType: "application/octet-stream", body: []byte{0xff, 0x00, 0xfe}, wantEncoding: EncodingBase64, wantContentType: "application/octet-stream"},
+		{name: "detected json", body: []byte(`{"a":1}`), wantContentType: "application/json"},
+		{name: "detected text", body: []byte(`hello`), wantEncoding: EncodingText, wantContentType: "text/plain; charset=utf-8"},
+		{name: "empty", body: nil, wantEncoding: EncodingText, wantContentType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var d Data
+			err := d.SetPayload(tt.contentType, tt.body)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPayload) {
+					t.Fatalf("want ErrInvalidPayload, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Encoding != tt.wantEncoding || d.ContentType != tt.wantContentType {
+				t.Fatalf("want %q/%q, got %q/%q", tt.wantEncoding, tt.wantContentType, d.Encoding, d.ContentType)
+			}
+			if !json.Valid(d.Data) {
+				t.Fatalf("data is not valid JSON: %s", d.Data)
+			}
+			body, err := d.Payload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != string(tt.body) {
+				t.Fatalf("want payload %q, got %q", tt.body, body)
+			}
+		})
+	}
+}
+
+func TestPublishDataRejectsInvalidPayload(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	tests := []Data{
+		{Topic: "topic", Data: []byte(`not json`)},
+		{Topic: "topic", Data: []byte(`"!!"`), Encoding: EncodingBase64},
+		{Topic: "topic", Data: []byte(`1`), Encoding: EncodingText},
+		{Topic: "topic", Data: []byte(`"a"`), Encoding: "zip"},
+	}
+	for _, msg := range tests {
+		if _, err := ps.PublishData(msg); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("want ErrInvalidPayload for %+v, got %v", msg, err)
+		}
+	}
+}
@@ -2,47 +2,326 @@
 package pubsub
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned when PubSub limits are exceeded
+var (
+	ErrTooManyTopics        = errors.New("too many topics")
+	ErrTooManySubscribers   = errors.New("too many subscribers for topic")
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+	ErrMessageTooLarge      = errors.New("message too large")
+	ErrClosed               = errors.New("pubsub closed")
+	ErrSubscribed           = errors.New("already subscribed")
 )
 
 // Data represents data message
 type Data struct {
-	Data   json.RawMessage `json:"data"`
-	Source string          `json:"source"`
-	Topic  string          `json:"topic"`
+	Data json.RawMessage `json:"data"`
+	// ContentType is the media type of the published payload.
+	ContentType string `json:"content_type,omitempty"`
+	// Encoding tells how a non-JSON payload is stored in Data, see
+	// SetPayload.
+	Encoding string `json:"encoding,omitempty"`
+	Source   string `json:"source"`
+	Topic    string `json:"topic"`
+	// Seq numbers messages of a topic when history is enabled.
+	Seq uint64 `json:"seq,omitempty"`
+	// ID uniquely identifies the message.
+	ID string `json:"id,omitempty"`
+	// Time is when the message was first published.
+	Time time.Time `json:"time,omitzero"`
+	// Node is the name of the node the message was first published on.
+	Node string `json:"node,omitempty"`
+	// Headers carry application metadata. Subscribers share the map and
+	// must not modify it.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Retain keeps the message as the last value of its topic, delivered
+	// to every new subscriber. A retained message with an empty payload
+	// clears the last value and is not delivered.
+	Retain bool `json:"retain,omitempty"`
+	// ExpiresAt is when the message goes stale. Expired messages are not
+	// delivered or replayed.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// DeliverAt delays publishing of the message until the given time.
+	DeliverAt time.Time `json:"deliver_at,omitzero"`
+	// ReplyTo is the topic responders publish replies to, see Request.
+	ReplyTo string `json:"reply_to,omitempty"`
+}
+
+// NewID returns a random message identifier
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
 }
 
 // DataChannel holds one subscription channel.
 // Subscribers receive from it; PubSub owns closing and sending.
 type DataChannel <-chan Data
 
+// OverflowPolicy decides what happens to a message published while
+// a subscriber's queue is full.
+type OverflowPolicy int
+
+const (
+	// Block waits until the subscriber makes room in its queue.
+	Block OverflowPolicy = iota
+	// DropNewest discards the message being published.
+	DropNewest
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest
+	// Disconnect closes the subscription of a slow consumer.
+	Disconnect
+)
+
+// String returns policy name
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// SubscribeOptions configures a single subscription
+type SubscribeOptions struct {
+	// BufferSize is the number of messages queued for the subscriber.
+	// Policies other than Block need a buffer of at least one message.
+	BufferSize int
+	// Overflow is applied when the queue is full.
+	Overflow OverflowPolicy
+	// Group makes the subscription a member of a consumer group. Each
+	// message is delivered to only one member of the group.
+	Group string
+	// Filter is an expression messages have to match to be delivered,
+	// see CompileFilter.
+	Filter string
+	// Interceptors run on messages of this subscription after the
+	// interceptors of PubSub.
+	Interceptors []DeliveryInterceptor
+	// Transport names the transport of the subscriber in system events.
+	Transport string
+	// Quiet keeps a short lived subscription, e.g. of a long poll, out of
+	// system events, including the topic events it causes. Rejected
+	// subscriptions are still reported.
+	Quiet bool
+}
+
+// Report describes the delivery of a published message to local
+// subscribers
+type Report struct {
+	// Message is the message as delivered to subscribers.
+	Message Data `json:"-"`
+	// Matched counts subscriptions selected to receive the message.
+	Matched int `json:"matched"`
+	// Delivered counts subscriptions the message was queued for.
+	Delivered int `json:"delivered"`
+	// Dropped counts subscriptions whose full queue rejected the message.
+	Dropped int `json:"dropped"`
+	// Scheduled is set for delayed messages, they are delivered later.
+	Scheduled bool `json:"scheduled,omitempty"`
+	// Duplicate is set for messages already published within the
+	// deduplication window, they are not delivered again.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Add sums delivery counters of other into r
+func (r *Report) Add(other Report) {
+	r.Matched += other.Matched
+	r.Delivered += other.Delivered
+	r.Dropped += other.Dropped
+}
+
+// SubscriptionStats holds delivery counters of one subscription
+type SubscriptionStats struct {
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	Queued    int    `json:"queued"`
+}
+
 type subscription struct {
-	ch     chan Data
-	done   chan struct{}
-	mu     sync.Mutex
-	closed bool
+	id        string
+	topic     string
+	group     string
+	ch        chan Data
+	done      chan struct{}
+	overflow  OverflowPolicy
+	filter    *Filter
+	intercept []DeliveryInterceptor
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	mu        sync.Mutex
+	closed    bool
+	// lost collects dropped messages to dead letter once mu is released.
+	lost        []lostMessage
+	deadLetters bool
+	transport   string
+	quiet       bool
 }
 
-func newSubscription() *subscription {
+func newSubscription(id, topic string, opts SubscribeOptions, filter *Filter, intercept []DeliveryInterceptor) *subscription {
+	size := opts.BufferSize
+	if size < 1 && opts.Overflow != Block {
+		size = 1
+	}
 	return &subscription{
-		ch:   make(chan Data),
-		done: make(chan struct{}),
+		id:        id,
+		topic:     topic,
+		group:     opts.Group,
+		ch:        make(chan Data, size),
+		done:      make(chan struct{}),
+		overflow:  opts.Overflow,
+		filter:    filter,
+		intercept: intercept,
 	}
 }
 
-func (s *subscription) send(data Data) {
+// accepts reports whether the message passes the subscription filter
+func (s *subscription) accepts(in *filterInput) bool {
+	return s.filter == nil || s.filter.root.eval(in)
+}
+
+// outcome of queueing a message for one subscriber
+type outcome int
+
+const (
+	delivered outcome = iota
+	dropped
+	skipped
+	// disconnected means the message was dropped and the subscriber is
+	// too slow and has to be disconnected.
+	disconnected
+)
+
+// send queues data according to the overflow policy. It returns
+// messages lost by the subscriber when dead letters are enabled.
+func (s *subscription) send(data Data) (outcome, []lostMessage) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.closed {
-		return
+	result := s.deliver(data)
+	return result, s.takeLost()
+}
+
+// lose records a dropped message, the caller holds s.mu
+func (s *subscription) lose(data Data, reason string) {
+	s.dropped.Add(1)
+	if s.deadLetters {
+		s.lost = append(s.lost, lostMessage{msg: data, reason: reason})
 	}
+}
+
+// takeLost returns and clears lost messages, the caller holds s.mu
+func (s *subscription) takeLost() []lostMessage {
+	lost := s.lost
+	s.lost = nil
+	return lost
+}
+
+// deliver is send for callers already holding s.mu
+func (s *subscription) deliver(data Data) outcome {
+	return s.queue(data, s.overflow)
+}
 
-	select {
-	case s.ch <- data:
-	case <-s.done:
+// queue puts data on the channel according to overflow, the caller
+// holds s.mu
+func (s *subscription) queue(data Data, overflow OverflowPolicy) outcome {
+	if s.closed || data.expired(time.Now()) {
+		return skipped
+	}
+	data, ok := interceptDelivery(s.id, data, s.intercept)
+	if !ok {
+		return skipped
+	}
+
+	switch overflow {
+	case DropNewest:
+		select {
+		case s.ch <- data:
+		default:
+			s.lose(data, ReasonQueueFull)
+			return dropped
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- data:
+				s.delivered.Add(1)
+				return delivered
+			default:
+			}
+			select {
+			case old := <-s.ch:
+				s.lose(old, ReasonEvicted)
+			default:
+			}
+		}
+	case Disconnect:
+		select {
+		case s.ch <- data:
+		default:
+			s.lose(data, ReasonDisconnected)
+			return disconnected
+		}
+	default:
+		select {
+		case s.ch <- data:
+		case <-s.done:
+			return skipped
+		}
+	}
+	s.delivered.Add(1)
+	return delivered
+}
+
+// replay delivers backlog ahead of live messages. The caller locks s.mu
+// before the subscription becomes visible to Publish, so live messages
+// wait until replay releases it. History is queued with Block semantics
+// whatever the overflow policy, so a backlog larger than the buffer
+// arrives without gaps; the policy applies to live messages only.
+func (s *subscription) replay(backlog []Data) {
+	defer s.mu.Unlock()
+	for _, data := range backlog {
+		if !s.accepts(&filterInput{msg: data}) {
+			continue
+		}
+		if s.queue(data, Block) != skipped {
+			continue
+		}
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+	}
+}
+
+func (s *subscription) stats() SubscriptionStats {
+	return SubscriptionStats{
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Queued:    len(s.ch),
 	}
 }
 
@@ -60,81 +339,537 @@ func (s *subscription) close() {
 
 // PubSub implements publish subscribe pattern
 type PubSub struct {
-	rm   sync.RWMutex
-	subs map[string]map[string]*subscription
-	// maxTopics caps the number of distinct topics.
-	// Both the topic count and per-topic subscriber count share this limit.
-	maxTopics int
+	// root indexes subscriptions by pattern tokens so Publish can match
+	// wildcard subscriptions without scanning every pattern. It is read
+	// without locks.
+	root *node
+	// trieMu serializes adding and pruning trie nodes.
+	trieMu sync.Mutex
+	// locks serialize changes to subscriptions of patterns hashing to
+	// the same lock. Publishing to a Storage holds the lock of the topic
+	// so SubscribeFrom reads the backlog and registers atomically.
+	locks [lockShards]sync.Mutex
+	// topics and count are the numbers of subscribed patterns and of
+	// all subscriptions, replies of subscriptions to reply topics.
+	topics  atomic.Int64
+	count   atomic.Int64
+	replies atomic.Int64
+	// retainMu guards retained. Retained publishes hold it for writing
+	// and new subscriptions for reading, so a new subscriber gets either
+	// the retained or the live message, never both.
+	retainMu sync.RWMutex
+	// retained holds the last retained message by topic.
+	retained map[string]Data
+	// delayed holds messages until their DeliverAt.
+	delayed *scheduler
+	// seen holds keys of recently published messages.
+	seen dedupe
+	// MaxTopics caps the number of distinct subscribed topics.
+	MaxTopics int
+	// MaxSubscribers caps the number of subscribers of one topic.
+	MaxSubscribers int
+	// MaxSubscriptions caps subscriptions of all topics, zero means no limit.
+	MaxSubscriptions int
+	// MaxRequests caps requests waiting for a reply, zero means no limit.
+	// Reply topics count toward it instead of MaxTopics, MaxSubscribers
+	// and MaxSubscriptions, so pending requests never keep other topics
+	// from being subscribed.
+	MaxRequests int
+	// MaxMessageSize caps published payloads in bytes before they are
+	// encoded as text or base64, zero means no limit.
+	MaxMessageSize int
+	// MaxRetained caps the number of topics with a retained message,
+	// zero means no limit.
+	MaxRetained int
+	// MaxScheduled caps the number of delayed messages waiting for
+	// delivery, zero means no limit.
+	MaxScheduled int
+	// DedupeWindow is how long message IDs given by publishers are
+	// remembered per topic. A message published again with the same ID
+	// within the window is acknowledged but not delivered. Zero disables
+	// deduplication.
+	DedupeWindow time.Duration
+	// DedupeSize caps the number of remembered message IDs.
+	DedupeSize int
+	// DeadLetters republishes messages subscribers drop or fail to
+	// receive to dead letter topics, see DeadLetter.
+	DeadLetters bool
+	// SystemEvents publishes subscription lifecycle events to system
+	// topics, see SystemEvent.
+	SystemEvents bool
+	// GroupBalance selects the member of a consumer group receiving a message.
+	GroupBalance Balance
+	// Node names this instance in published messages.
+	Node string
+	// HistorySize is the number of messages retained per topic for
+	// SubscribeFrom when no Storage is given. Zero disables history and
+	// sequence numbers.
+	HistorySize int
+	// HistoryAge additionally evicts retained messages older than it.
+	HistoryAge time.Duration
+	// Storage retains published messages, it takes precedence over
+	// HistorySize and HistoryAge.
+	Storage Storage
+	// PublishInterceptors run in order on every published message.
+	PublishInterceptors []PublishInterceptor
+	// DeliveryInterceptors run in order on every message queued for
+	// a subscriber.
+	DeliveryInterceptors []DeliveryInterceptor
+	// Log reports messages published in the background that failed.
+	Log *log.Logger
 }
 
-// New creates PubSub object. The cap argument limits the total number of
-// distinct topics (and also the number of subscribers per topic).
-func New(cap int) *PubSub {
-	return &PubSub{
-		subs:      make(map[string]map[string]*subscription, cap),
-		maxTopics: cap,
+// New creates PubSub object with sensible defaults
+func New(opts ...func(*PubSub)) *PubSub {
+	ps := &PubSub{
+		root:           newNode(),
+		retained:       make(map[string]Data),
+		MaxTopics:      100,
+		MaxSubscribers: 100,
+		MaxRequests:    1000,
+		MaxMessageSize: 1 << 20,
+		MaxRetained:    1000,
+		MaxScheduled:   10000,
+		DedupeWindow:   5 * time.Minute,
+		DedupeSize:     100000,
+		Log:            log.New(os.Stdout, "[PUBSUB] ", log.LstdFlags),
+	}
+	ps.delayed = newScheduler(func(message Data) {
+		// a delayed message failing to store is lost, as if it had
+		// failed to publish
+		if _, err := ps.dispatch(message); err != nil {
+			ps.Log.Printf("Error publishing delayed message to %q: %v", message.Topic, err)
+		}
+	})
+	if hostname, err := os.Hostname(); err == nil {
+		ps.Node = hostname
+	}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	if ps.Storage == nil && ps.HistorySize > 0 {
+		ps.Storage = NewMemoryStorage(ps.HistorySize, ps.HistoryAge)
 	}
+	return ps
 }
 
-// Subscribe creates new DataChannel as subscription to send messages
-func (ps *PubSub) Subscribe(id, topic string) (DataChannel, error) {
-	ps.rm.Lock()
-	defer ps.rm.Unlock()
+// Close drops delayed messages and releases the storage
+func (ps *PubSub) Close() error {
+	ps.delayed.close()
+	if ps.Storage == nil {
+		return nil
+	}
+	return ps.Storage.Close()
+}
 
-	if _, ok := ps.subs[topic]; !ok {
-		if len(ps.subs) >= ps.maxTopics {
-			return nil, errors.New("new topics cannot be added")
+// Subscribe creates new DataChannel as subscription to send messages.
+// The topic may be a pattern, see ValidatePattern. Retained messages of
+// matching topics are delivered first.
+// By default the channel is unbuffered and Publish blocks until the
+// subscriber receives the message.
+func (ps *PubSub) Subscribe(id, topic string, opts ...func(*SubscribeOptions)) (DataChannel, error) {
+	sub, _, err := ps.subscribePattern(id, topic, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sub.ch, nil
+}
+
+// subscribePattern registers a subscription with retained messages of
+// topics matching the pattern as backlog
+func (ps *PubSub) subscribePattern(id, topic string, opts []func(*SubscribeOptions)) (*subscription, bool, error) {
+	if err := ValidatePattern(topic); err != nil {
+		return nil, false, fmt.Errorf("%w: %q", err, topic)
+	}
+
+	// no message is retained between reading the backlog and registering
+	return ps.subscribe(id, topic, func() ([]Data, error) {
+		return ps.retainedFor(topic), nil
+	}, ps.retainMu.RLocker(), opts)
+}
+
+// SubscribeFrom works like Subscribe but first replays retained messages
+// of topic with sequence numbers greater than seq. Replayed and live
+// messages are delivered in order without gaps or duplicates. Replayed
+// messages are never dropped, the overflow policy applies to live messages
+// once the backlog is queued. The topic cannot be a pattern since sequences are kept per topic.
+func (ps *PubSub) SubscribeFrom(id, topic string, seq uint64, opts ...func(*SubscribeOptions)) (DataChannel, error) {
+	if err := ValidateTopic(topic); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, topic)
+	}
+	sub, _, err := ps.subscribe(id, topic, func() ([]Data, error) {
+		if ps.Storage == nil {
+			return nil, nil
 		}
-		ps.subs[topic] = make(map[string]*subscription)
+		return ps.Storage.Since(topic, seq)
+	}, nil, opts)
+	if err != nil {
+		return nil, err
 	}
+	return sub.ch, nil
+}
 
-	if sub, ok := ps.subs[topic][id]; ok {
-		return sub.ch, nil
+// LastSeq returns the last sequence number of topic. It reports false
+// when no history is kept, so there are no sequence numbers.
+func (ps *PubSub) LastSeq(topic string) (uint64, bool) {
+	if ps.Storage == nil {
+		return 0, false
 	}
+	return ps.Storage.Last(topic), true
+}
 
-	if len(ps.subs[topic]) >= ps.maxTopics {
-		return nil, errors.New("new ids cannot be added")
+// subscribe registers a subscription. The backlog function is called with
+// the lock of the topic held so no message is stored between reading the
+// backlog and registering the subscription, an optional lock is held
+// around both. It reports whether the subscription is new, an existing
+// subscription of id is returned as is.
+func (ps *PubSub) subscribe(id, topic string, backlog func() ([]Data, error), lock sync.Locker, opts []func(*SubscribeOptions)) (*subscription, bool, error) {
+	var options SubscribeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	var filter *Filter
+	if options.Filter != "" {
+		var err error
+		if filter, err = CompileFilter(options.Filter); err != nil {
+			return nil, false, err
+		}
 	}
 
-	sub := newSubscription()
-	ps.subs[topic][id] = sub
-	return sub.ch, nil
+	if lock != nil {
+		lock.Lock()
+	}
+	sub, created, joined, err := ps.register(id, topic, backlog, options, filter)
+	if lock != nil {
+		lock.Unlock()
+	}
+	// subscribers of events may publish, so no lock is held
+	if !options.Quiet || err != nil {
+		ps.emitSubscribe(SystemEvent{Topic: topic, Subscriber: id, Group: options.Group, Transport: options.Transport}, created, joined, err)
+	}
+	return sub, joined, err
 }
 
-// Publish sends a message to DataChannel
-func (ps *PubSub) Publish(source, topic string, data []byte) {
-	ps.rm.RLock()
-	subs := make([]*subscription, 0, len(ps.subs[topic]))
-	for _, sub := range ps.subs[topic] {
-		subs = append(subs, sub)
+// register adds a subscription under the lock of topic and reports
+// whether it created the topic and the subscription
+func (ps *PubSub) register(id, topic string, backlog func() ([]Data, error), options SubscribeOptions, filter *Filter) (sub *subscription, created, joined bool, err error) {
+	mu := ps.lockFor(topic)
+	mu.Lock()
+	defer mu.Unlock()
+
+	tokens := strings.Split(topic, separator)
+	var ts *topicSubs
+	n, ok := ps.root.find(tokens)
+	if ok {
+		ts = n.subs.Load()
+	}
+	if ts != nil {
+		if sub, ok := ts.subs[id]; ok {
+			return sub, false, false, nil
+		}
+	}
+	var replay []Data
+	if backlog != nil {
+		if replay, err = backlog(); err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	if err := ps.reserve(topic, ts); err != nil {
+		return nil, false, false, err
+	}
+	sub = newSubscription(id, topic, options, filter, append(slices.Clip(ps.DeliveryInterceptors), options.Interceptors...))
+	sub.deadLetters = ps.DeadLetters
+	sub.transport = options.Transport
+	sub.quiet = options.Quiet
+	if len(replay) > 0 {
+		sub.mu.Lock()
+		go sub.replay(replay)
+	}
+	if ts == nil {
+		ps.trieMu.Lock()
+		ps.root.insert(tokens).subs.Store(newTopicSubs().with(sub))
+		ps.trieMu.Unlock()
+	} else {
+		n.subs.Store(ts.with(sub))
+	}
+	return sub, ts == nil, true, nil
+}
+
+// reserve counts a new subscription of a pattern currently subscribed by
+// ts, or nil for a new pattern, failing when it exceeds limits
+func (ps *PubSub) reserve(pattern string, ts *topicSubs) error {
+	if isReply(pattern) {
+		if ps.replies.Add(1) > int64(ps.MaxRequests) && ps.MaxRequests > 0 {
+			ps.replies.Add(-1)
+			return ErrTooManyRequests
+		}
+		return nil
+	}
+
+	subscribers := 0
+	if ts != nil {
+		subscribers = len(ts.subs)
+	} else if ps.topics.Add(1) > int64(ps.MaxTopics) {
+		ps.topics.Add(-1)
+		return ErrTooManyTopics
+	}
+
+	var err error
+	if subscribers >= ps.MaxSubscribers {
+		err = ErrTooManySubscribers
+	} else if ps.count.Add(1) > int64(ps.MaxSubscriptions) && ps.MaxSubscriptions > 0 {
+		ps.count.Add(-1)
+		err = ErrTooManySubscriptions
+	}
+	if err != nil && ts == nil {
+		ps.topics.Add(-1)
+	}
+	return err
+}
+
+// lockShards is the number of locks patterns are hashed to
+const lockShards = 64
+
+// lockFor returns the lock of a pattern
+func (ps *PubSub) lockFor(pattern string) *sync.Mutex {
+	return &ps.locks[hash(pattern)%lockShards]
+}
+
+// hash is 32 bit FNV-1a of s
+func hash(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// Publish sends a message to DataChannel of every subscription whose
+// pattern matches topic. With a Storage the message is retained first.
+// The content type of data is detected, see SetPayload.
+// It reports how the message was delivered to local subscribers.
+func (ps *PubSub) Publish(source, topic string, data []byte) (Report, error) {
+	message := Data{Source: source, Topic: topic}
+	if err := message.SetPayload("", data); err != nil {
+		return Report{Message: message}, err
+	}
+	return ps.PublishData(message)
+}
+
+// PublishData publishes a complete message. Missing ID, Time and Node
+// are filled in, messages relayed from other nodes keep their own.
+// Publish interceptors run once the message is complete. Messages with
+// DeliverAt in the future are kept in memory until then. Messages with
+// an ID already published to the topic within DedupeWindow are skipped,
+// only IDs given by the publisher or relayed from another node are kept
+// for deduplication.
+// The report holds the message as delivered to subscribers.
+func (ps *PubSub) PublishData(message Data) (Report, error) {
+	if err := ps.validate(message); err != nil {
+		return Report{Message: message}, err
+	}
+	// generated IDs never repeat, remembering them only costs a lock
+	keyed := message.ID != ""
+	if !keyed {
+		message.ID = NewID()
+	}
+	if message.Time.IsZero() {
+		message.Time = time.Now().UTC()
+	}
+	if message.Node == "" {
+		message.Node = ps.Node
+	}
+	message.Seq = 0
+	if len(ps.PublishInterceptors) > 0 {
+		if err := Intercept(&message, ps.PublishInterceptors); err != nil {
+			return Report{Message: message}, err
+		}
+		if err := ps.validate(message); err != nil {
+			return Report{Message: message}, err
+		}
+	}
+
+	now := time.Now()
+	if message.expired(now) {
+		return Report{Message: message}, nil
+	}
+	if keyed && ps.DedupeWindow > 0 {
+		key := dedupeKey(message)
+		if ps.seen.add(key, now, ps.DedupeWindow, ps.DedupeSize) {
+			return Report{Message: message, Duplicate: true}, nil
+		}
+		report, err := ps.schedule(message, now)
+		if err != nil {
+			ps.seen.forget(key)
+		}
+		return report, err
+	}
+	return ps.schedule(message, now)
+}
+
+// schedule dispatches message now or keeps it until its DeliverAt
+func (ps *PubSub) schedule(message Data, now time.Time) (Report, error) {
+	if message.DeliverAt.After(now) {
+		if err := ps.delayed.schedule(message, ps.MaxScheduled); err != nil {
+			return Report{Message: message}, err
+		}
+		return Report{Message: message, Scheduled: true}, nil
+	}
+	return ps.dispatch(message)
+}
+
+// dispatch stores message and sends it to subscribers
+func (ps *PubSub) dispatch(message Data) (Report, error) {
+	message, subs, err := ps.route(message)
+	if err != nil {
+		return Report{Message: message}, err
 	}
-	ps.rm.RUnlock()
 
-	message := Data{Data: data, Source: source, Topic: topic}
+	report := Report{Message: message, Matched: len(subs)}
 	for _, sub := range subs {
-		sub.send(message)
+		result, lost := sub.send(message)
+		switch result {
+		case delivered:
+			report.Delivered++
+		case dropped:
+			report.Dropped++
+		case disconnected:
+			report.Dropped++
+			ps.remove(sub)
+		}
+		ps.deadLetters(sub.id, lost)
+	}
+	return report, nil
+}
+
+// route stores message and returns subscriptions it is delivered to.
+// It takes no locks unless the message is retained or stored.
+func (ps *PubSub) route(message Data) (Data, []*subscription, error) {
+	if message.Retain {
+		ps.retainMu.Lock()
+		defer ps.retainMu.Unlock()
+
+		if err := ps.canRetain(message); err != nil {
+			return message, nil, err
+		}
+		if message.clearsRetained() {
+			ps.retain(message)
+			return message, nil, nil
+		}
+	}
+	if ps.Storage != nil {
+		mu := ps.lockFor(message.Topic)
+		mu.Lock()
+		defer mu.Unlock()
+
+		var err error
+		if message, err = ps.Storage.Append(message); err != nil {
+			return message, nil, err
+		}
 	}
+	if message.Retain {
+		ps.retain(message)
+	}
+
+	var subs []*subscription
+	in := &filterInput{msg: message}
+	for _, ts := range ps.root.matchTopic(strings.Split(message.Topic, separator), nil) {
+		subs = ts.collect(subs, ps.GroupBalance, in)
+	}
+	return message, subs, nil
+}
+
+// validate checks that message can be published
+func (ps *PubSub) validate(message Data) error {
+	if err := ValidateTopic(message.Topic); err != nil {
+		return fmt.Errorf("%w: %q", err, message.Topic)
+	}
+	if message.ReplyTo != "" {
+		if err := ValidateTopic(message.ReplyTo); err != nil {
+			return fmt.Errorf("%w: reply to %q", err, message.ReplyTo)
+		}
+	}
+	if message.clearsRetained() {
+		return nil
+	}
+	size, err := message.payloadSize()
+	if err != nil {
+		return err
+	}
+	if ps.MaxMessageSize > 0 && size > ps.MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+// Stats returns delivery counters of the subscription
+func (ps *PubSub) Stats(id, topic string) (SubscriptionStats, bool) {
+	sub, ok := ps.lookup(id, topic)
+	if !ok {
+		return SubscriptionStats{}, false
+	}
+	return sub.stats(), true
 }
 
 // Unsubscribe removes DataChannel subscription from local cache
 func (ps *PubSub) Unsubscribe(id, topic string) {
-	ps.rm.Lock()
-	defer ps.rm.Unlock()
+	if sub, ok := ps.lookup(id, topic); ok {
+		ps.remove(sub)
+	}
+}
 
-	topicSubs, ok := ps.subs[topic]
+// lookup finds a subscription
+func (ps *PubSub) lookup(id, topic string) (*subscription, bool) {
+	n, ok := ps.root.find(strings.Split(topic, separator))
 	if !ok {
-		return
+		return nil, false
 	}
+	ts := n.subs.Load()
+	if ts == nil {
+		return nil, false
+	}
+	sub, ok := ts.subs[id]
+	return sub, ok
+}
 
-	sub, ok := topicSubs[id]
-	if !ok {
+// remove drops sub from the registry unless it was already replaced
+func (ps *PubSub) remove(sub *subscription) {
+	mu := ps.lockFor(sub.topic)
+	mu.Lock()
+	tokens := strings.Split(sub.topic, separator)
+	n, ok := ps.root.find(tokens)
+	var ts *topicSubs
+	if ok {
+		ts = n.subs.Load()
+	}
+	if ts == nil || ts.subs[sub.id] != sub {
+		mu.Unlock()
 		return
 	}
 
-	delete(topicSubs, id)
-	if len(topicSubs) == 0 {
-		delete(ps.subs, topic)
+	reply := isReply(sub.topic)
+	if reply {
+		ps.replies.Add(-1)
+	} else {
+		ps.count.Add(-1)
+	}
+	deleted := false
+	if ts = ts.without(sub); len(ts.subs) > 0 {
+		n.subs.Store(ts)
+	} else {
+		deleted = true
+		ps.trieMu.Lock()
+		n.subs.Store(nil)
+		ps.root.prune(tokens)
+		ps.trieMu.Unlock()
+		if !reply {
+			ps.topics.Add(-1)
+		}
 	}
+	mu.Unlock()
 
 	sub.close()
+	ps.emitUnsubscribe(sub, deleted)
 }
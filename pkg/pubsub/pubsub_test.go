@@ -1,6 +1,7 @@
 package pubsub
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -9,7 +10,7 @@ import (
 func TestPubSubSingleSubscriber(t *testing.T) {
 	t.Parallel()
 
-	ps := New(2)
+	ps := New()
 	ch, err := ps.Subscribe("user", "topic")
 	if err != nil {
 		t.Fatal(err)
@@ -27,12 +28,17 @@ func TestPubSubSingleSubscriber(t *testing.T) {
 func TestPubSubMultiSubscribers(t *testing.T) {
 	t.Parallel()
 
-	ps := New(3)
-	ch1, err := ps.Subscribe("user1", "topic")
+	// Subscribers are served in no particular order, so buffer the
+	// channels to let Publish reach both before they are read.
+	buffered := func(o *SubscribeOptions) {
+		o.BufferSize = 1
+	}
+	ps := New()
+	ch1, err := ps.Subscribe("user1", "topic", buffered)
 	if err != nil {
 		t.Fatal(err)
 	}
-	ch2, err := ps.Subscribe("user2", "topic")
+	ch2, err := ps.Subscribe("user2", "topic", buffered)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +57,7 @@ func TestPubSubMultiSubscribers(t *testing.T) {
 func TestUnsubscribeClosesChannel(t *testing.T) {
 	t.Parallel()
 
-	ps := New(1)
+	ps := New()
 	ch, err := ps.Subscribe("user", "topic")
 	if err != nil {
 		t.Fatal(err)
@@ -72,7 +78,10 @@ func TestUnsubscribeClosesChannel(t *testing.T) {
 func TestSubscribeExistingWhenAtCapacity(t *testing.T) {
 	t.Parallel()
 
-	ps := New(1)
+	ps := New(func(ps *PubSub) {
+		ps.MaxTopics = 1
+		ps.MaxSubscribers = 1
+	})
 	first, err := ps.Subscribe("user", "topic")
 	if err != nil {
 		t.Fatal(err)
@@ -86,8 +95,109 @@ func TestSubscribeExistingWhenAtCapacity(t *testing.T) {
 	}
 }
 
+func TestSubscribeLimits(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.MaxTopics = 2
+		ps.MaxSubscribers = 2
+		ps.MaxSubscriptions = 3
+	})
+	subscribe := func(id, topic string) error {
+		_, err := ps.Subscribe(id, topic)
+		return err
+	}
+
+	for _, sub := range [][2]string{{"a", "one"}, {"b", "one"}, {"a", "two"}} {
+		if err := subscribe(sub[0], sub[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := subscribe("c", "one"); !errors.Is(err, ErrTooManySubscribers) {
+		t.Fatalf("want ErrTooManySubscribers, got %v", err)
+	}
+	if err := subscribe("a", "three"); !errors.Is(err, ErrTooManyTopics) {
+		t.Fatalf("want ErrTooManyTopics, got %v", err)
+	}
+	if err := subscribe("b", "two"); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Fatalf("want ErrTooManySubscriptions, got %v", err)
+	}
+
+	ps.Unsubscribe("a", "one")
+	if err := subscribe("b", "two"); err != nil {
+		t.Fatalf("expected room after unsubscribe, got %v", err)
+	}
+}
+
+func TestPublishMessageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.MaxMessageSize = 4
+	})
+	if _, err := ps.Publish("source", "topic", []byte(`"abcd"`)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("want ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestPublishMessageSizeOfDecodedPayload(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.MaxMessageSize = 4
+	})
+	msg := Data{Topic: "topic", Source: "source"}
+	if err := msg.SetPayload("application/octet-stream", []byte{0xff, 0xfe, 0xfd, 0xfc}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(msg); err != nil {
+		t.Fatalf("want payload of 4 bytes published, got %v", err)
+	}
+	if err := msg.SetPayload("application/octet-stream", []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(msg); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("want ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestPublishDataFillsMetadata(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.Node = "node"
+	})
+	ch, err := ps.Subscribe("user", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`1`), Headers: map[string]string{"Trace": "abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, ch)
+	if got.ID == "" || got.ID != published.Message.ID {
+		t.Fatalf("want id %q, got %q", published.Message.ID, got.ID)
+	}
+	if got.Time.IsZero() || got.Node != "node" || got.Headers["Trace"] != "abc" {
+		t.Fatalf("unexpected metadata: %+v", got)
+	}
+
+	relayed := Data{Topic: "topic", Data: []byte(`2`), ID: "remote-id", Node: "remote", Time: time.Unix(1, 0).UTC()}
+	if _, err := ps.PublishData(relayed); err != nil {
+		t.Fatal(err)
+	}
+	got = receive(t, ch)
+	if got.ID != relayed.ID || got.Node != relayed.Node || !got.Time.Equal(relayed.Time) {
+		t.Fatalf("want relayed metadata %+v, got %+v", relayed, got)
+	}
+}
+
 func TestConcurrentPublishAndUnsubscribe(t *testing.T) {
-	ps := New(100)
+	ps := New()
 	done := make(chan struct{})
 
 	for i := 0; i < 20; i++ {
@@ -144,3 +254,238 @@ func receive(t *testing.T, ch DataChannel) Data {
 		return Data{}
 	}
 }
+
+func TestOverflowPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		overflow    OverflowPolicy
+		want        []string
+		wantDropped uint64
+		wantClosed  bool
+	}{
+		{name: "drop newest", overflow: DropNewest, want: []string{"1", "2"}, wantDropped: 1},
+		{name: "drop oldest", overflow: DropOldest, want: []string{"2", "3"}, wantDropped: 1},
+		{name: "disconnect", overflow: Disconnect, want: []string{"1", "2"}, wantDropped: 1, wantClosed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ps := New()
+			ch, err := ps.Subscribe("user", "topic", func(o *SubscribeOptions) {
+				o.BufferSize = 2
+				o.Overflow = tt.overflow
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, data := range []string{"1", "2", "3"} {
+				ps.Publish("source", "topic", []byte(data))
+			}
+
+			stats, ok := ps.Stats("user", "topic")
+			if ok == tt.wantClosed {
+				t.Fatalf("want subscription registered %t, got %t", !tt.wantClosed, ok)
+			}
+			if ok && stats.Dropped != tt.wantDropped {
+				t.Errorf("want %d dropped, got %d", tt.wantDropped, stats.Dropped)
+			}
+
+			for _, want := range tt.want {
+				out := receive(t, ch)
+				if string(out.Data) != want {
+					t.Errorf("want: %s, got: %s", want, string(out.Data))
+				}
+			}
+			if tt.wantClosed {
+				if _, ok := <-ch; ok {
+					t.Fatal("expected channel to be closed")
+				}
+			}
+		})
+	}
+}
+
+func TestSlowSubscriberDoesNotBlockPublish(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	if _, err := ps.Subscribe("slow", "topic", func(o *SubscribeOptions) {
+		o.Overflow = DropNewest
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fast, err := ps.Subscribe("fast", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 10
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			ps.Publish("source", "topic", []byte(`{"a":1}`))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on slow subscriber")
+	}
+	if len(fast) != 10 {
+		t.Fatalf("want 10 queued messages, got %d", len(fast))
+	}
+	stats, ok := ps.Stats("slow", "topic")
+	if !ok {
+		t.Fatal("expected slow subscription")
+	}
+	if stats.Delivered != 1 || stats.Dropped != 9 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSubscribeFromReplaysHistory(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.HistorySize = 10
+	})
+	for i := 1; i <= 3; i++ {
+		ps.Publish("source", "topic", []byte(fmt.Sprint(i)))
+	}
+
+	ch, err := ps.SubscribeFrom("user", "topic", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ps.Publish("source", "topic", []byte("4"))
+
+	for _, want := range []uint64{2, 3, 4} {
+		out := receive(t, ch)
+		if out.Seq != want || string(out.Data) != fmt.Sprint(want) {
+			t.Fatalf("want seq %d, got %+v", want, out)
+		}
+	}
+}
+
+func TestSubscribeFromWithoutGaps(t *testing.T) {
+	t.Parallel()
+
+	const total = 200
+	ps := New(func(ps *PubSub) {
+		ps.HistorySize = total
+	})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < total; i++ {
+			ps.Publish("source", "topic", []byte(`{"a":1}`))
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	ch, err := ps.SubscribeFrom("user", "topic", 0, func(o *SubscribeOptions) {
+		o.BufferSize = total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-published
+
+	for want := uint64(1); want <= total; want++ {
+		if out := receive(t, ch); out.Seq != want {
+			t.Fatalf("want seq %d, got %d", want, out.Seq)
+		}
+	}
+}
+
+func TestSubscribeFromBacklogLargerThanBuffer(t *testing.T) {
+	t.Parallel()
+
+	const total = 50
+	ps := New(func(ps *PubSub) {
+		ps.HistorySize = total
+		ps.DeadLetters = true
+	})
+	dlq, err := ps.Subscribe("every", DeadLetterPrefix+">", func(o *SubscribeOptions) {
+		o.BufferSize = total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < total; i++ {
+		ps.Publish("source", "topic", []byte(`{"a":1}`))
+	}
+
+	ch, err := ps.SubscribeFrom("user", "topic", 0, func(o *SubscribeOptions) {
+		o.BufferSize = 4
+		o.Overflow = DropOldest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(1); want <= total; want++ {
+		if out := receive(t, ch); out.Seq != want {
+			t.Fatalf("want seq %d, got %d", want, out.Seq)
+		}
+	}
+	select {
+	case msg := <-dlq:
+		t.Fatalf("replay dead lettered %+v", msg)
+	default:
+	}
+}
+
+func TestSubscribeFromRejectsPattern(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	if _, err := ps.SubscribeFrom("user", "orders.*", 0); !errors.Is(err, ErrInvalidTopic) {
+		t.Fatalf("want ErrInvalidTopic, got %v", err)
+	}
+}
+
+func TestPublishReport(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	subscribe := func(id string, opts func(*SubscribeOptions)) DataChannel {
+		ch, err := ps.Subscribe(id, "topic", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+	subscribe("full", func(o *SubscribeOptions) { o.Overflow = DropNewest })
+	subscribe("slow", func(o *SubscribeOptions) { o.Overflow = Disconnect })
+	subscribe("filtered", func(o *SubscribeOptions) {
+		o.Overflow = DropNewest
+		o.Filter = `data.a == 2`
+	})
+
+	report, err := ps.Publish("source", "topic", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 2 || report.Delivered != 2 || report.Dropped != 0 || report.Message.ID == "" {
+		t.Fatalf("unexpected first report: %+v", report)
+	}
+
+	report, err = ps.Publish("source", "topic", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 2 || report.Delivered != 0 || report.Dropped != 2 {
+		t.Fatalf("unexpected second report: %+v", report)
+	}
+	if _, ok := ps.Stats("slow", "topic"); ok {
+		t.Fatal("slow subscriber should be disconnected")
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ReplyPrefix starts the ephemeral topics replies to requests are
+// published to
+const ReplyPrefix = reservedPrefix + "reply" + separator
+
+var (
+	// ErrNoResponders is returned for requests no subscriber received
+	ErrNoResponders = errors.New("no responders")
+	// ErrTooManyRequests is returned when PubSub.MaxRequests requests
+	// wait for replies
+	ErrTooManyRequests = errors.New("too many pending requests")
+)
+
+// isReply reports whether topic is a reply topic
+func isReply(topic string) bool {
+	return strings.HasPrefix(topic, ReplyPrefix)
+}
+
+// ReplyTopic returns a new unique reply topic
+func ReplyTopic() string {
+	return ReplyPrefix + NewID()
+}
+
+// RequestOptions configure a request
+type RequestOptions struct {
+	// Publish publishes the request, PublishData by default. Transports
+	// publishing to a cluster return the report summed over every node so
+	// a request received only by other nodes has responders.
+	Publish func(Data) (Report, error)
+	// Transport names the transport of the requester in system events.
+	Transport string
+}
+
+// Request publishes message with a new reply topic in ReplyTo and waits
+// for the first message published to it, which is the reply. Responders
+// publish their reply to the ReplyTo of the message they received. It
+// fails with ErrNoResponders when no subscriber receives the request and
+// with the error of ctx when no reply arrives before ctx is done.
+func (ps *PubSub) Request(ctx context.Context, message Data, opts ...func(*RequestOptions)) (Data, error) {
+	options := RequestOptions{Publish: ps.PublishData}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	message.ReplyTo = ReplyTopic()
+	sub, err := ps.SubscribeContext(ctx, message.ReplyTo, message.ReplyTo, func(o *SubscribeOptions) {
+		o.BufferSize = 1
+		o.Overflow = DropNewest
+		o.Transport = options.Transport
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	defer sub.Close()
+
+	report, err := options.Publish(message)
+	if err != nil {
+		return Data{}, err
+	}
+	if report.Matched == 0 && !report.Scheduled {
+		return Data{}, ErrNoResponders
+	}
+
+	select {
+	case reply, ok := <-sub.C():
+		if !ok {
+			// the subscription closes when ctx is done
+			if err := ctx.Err(); err != nil {
+				return Data{}, err
+			}
+			return Data{}, ErrClosed
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return Data{}, ctx.Err()
+	}
+}
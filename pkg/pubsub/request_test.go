@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequest(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	ch, err := ps.Subscribe("responder", "rpc.echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range ch {
+			if _, err := ps.Publish("responder", msg.ReplyTo, msg.Data); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := ps.Request(ctx, Data{Source: "client", Topic: "rpc.echo", Data: []byte(`{"ping":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Data) != `{"ping":1}` || reply.Source != "responder" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	if _, ok := ps.Stats(reply.Topic, reply.Topic); ok {
+		t.Fatal("reply subscription should be removed")
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ps.Request(ctx, Data{Source: "client", Topic: "rpc.none", Data: []byte(`1`)}); !errors.Is(err, ErrNoResponders) {
+		t.Fatalf("want ErrNoResponders, got %v", err)
+	}
+
+	if _, err := ps.Subscribe("silent", "rpc.silent", func(o *SubscribeOptions) { o.BufferSize = 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Request(ctx, Data{Source: "client", Topic: "rpc.silent", Data: []byte(`1`)}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if got := ps.replies.Load(); got != 0 {
+		t.Fatalf("reply subscriptions should be removed, got %d", got)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.MaxTopics = 2
+		ps.MaxRequests = 3
+	})
+	if _, err := ps.Subscribe("silent", "rpc.silent", func(o *SubscribeOptions) {
+		o.BufferSize = 8
+		o.Overflow = DropNewest
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for range 3 {
+		wg.Go(func() {
+			_, _ = ps.Request(ctx, Data{Source: "client", Topic: "rpc.silent", Data: []byte(`1`)})
+		})
+	}
+	for deadline := time.Now().Add(time.Second); ps.replies.Load() < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("requests are not pending")
+		}
+	}
+
+	// pending requests don't count as topics
+	if _, err := ps.Subscribe("x", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Request(ctx, Data{Source: "client", Topic: "rpc.silent", Data: []byte(`1`)}); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("want ErrTooManyRequests, got %v", err)
+	}
+}
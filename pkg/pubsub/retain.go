@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrTooManyRetained is returned when retaining a message of a new topic
+// would exceed PubSub.MaxRetained
+var ErrTooManyRetained = errors.New("too many retained messages")
+
+// clearsRetained reports whether message removes the retained message of
+// its topic instead of being published
+func (d Data) clearsRetained() bool {
+	if !d.Retain {
+		return false
+	}
+	body, err := d.Payload()
+	return err == nil && len(body) == 0
+}
+
+// canRetain checks that retaining message does not exceed MaxRetained,
+// the caller holds ps.retainMu
+func (ps *PubSub) canRetain(message Data) error {
+	if message.clearsRetained() || ps.MaxRetained <= 0 {
+		return nil
+	}
+	if _, ok := ps.retained[message.Topic]; !ok && len(ps.retained) >= ps.MaxRetained {
+		return ErrTooManyRetained
+	}
+	return nil
+}
+
+// retain stores message as the last value of its topic or clears it,
+// the caller holds ps.retainMu for writing
+func (ps *PubSub) retain(message Data) {
+	if message.clearsRetained() {
+		delete(ps.retained, message.Topic)
+		return
+	}
+	ps.retained[message.Topic] = message
+}
+
+// retainedFor returns retained messages of topics matching pattern
+// ordered by topic, the caller holds ps.retainMu
+func (ps *PubSub) retainedFor(pattern string) []Data {
+	var out []Data
+	for topic, message := range ps.retained {
+		if Match(pattern, topic) {
+			out = append(out, message)
+		}
+	}
+	slices.SortFunc(out, func(a, b Data) int {
+		return strings.Compare(a.Topic, b.Topic)
+	})
+	return out
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetainedMessageDeliveredToNewSubscribers(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	for _, msg := range []Data{
+		{Topic: "sensors.a", Data: []byte(`1`), Retain: true},
+		{Topic: "sensors.a", Data: []byte(`2`), Retain: true},
+		{Topic: "sensors.b", Data: []byte(`3`), Retain: true},
+		{Topic: "sensors.b", Data: []byte(`4`)},
+	} {
+		if _, err := ps.PublishData(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ch, err := ps.Subscribe("id", "sensors.*", func(o *SubscribeOptions) {
+		o.BufferSize = 4
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`2`, `3`} {
+		got := <-ch
+		if string(got.Data) != want || !got.Retain {
+			t.Fatalf("want retained message %s, got %+v", want, got)
+		}
+	}
+	if _, err := ps.PublishData(Data{Topic: "sensors.a", Data: []byte(`5`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ch; string(got.Data) != `5` {
+		t.Fatalf("want live message after retained ones, got %s", got.Data)
+	}
+}
+
+func TestEmptyRetainedMessageClears(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.MaxRetained = 1 })
+	if _, err := ps.PublishData(Data{Topic: "a", Data: []byte(`1`), Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(Data{Topic: "b", Data: []byte(`1`), Retain: true}); !errors.Is(err, ErrTooManyRetained) {
+		t.Fatalf("want ErrTooManyRetained, got %v", err)
+	}
+
+	ch, err := ps.Subscribe("live", "a", func(o *SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	if _, err := ps.PublishData(Data{Topic: "a", Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ch) != 0 {
+		t.Fatal("clearing message should not be delivered")
+	}
+
+	late, err := ps.Subscribe("late", "a", func(o *SubscribeOptions) {
+		o.BufferSize = 1
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(Data{Topic: "b", Data: []byte(`2`), Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(late) != 0 {
+		t.Fatal("cleared message should not be delivered")
+	}
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyScheduled is returned when delaying a message would exceed
+// PubSub.MaxScheduled
+var ErrTooManyScheduled = errors.New("too many scheduled messages")
+
+// expired reports whether the message expired at now
+func (d Data) expired(now time.Time) bool {
+	return !d.ExpiresAt.IsZero() && !now.Before(d.ExpiresAt)
+}
+
+// scheduler holds delayed messages in a heap ordered by DeliverAt. A
+// single timer fires when the earliest message is due.
+type scheduler struct {
+	mu      sync.Mutex
+	queue   delayQueue
+	timer   *time.Timer
+	closed  bool
+	publish func(Data)
+}
+
+func newScheduler(publish func(Data)) *scheduler {
+	return &scheduler{publish: publish}
+}
+
+// schedule queues message until its DeliverAt
+func (s *scheduler) schedule(message Data, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if limit > 0 && len(s.queue) >= limit {
+		return ErrTooManyScheduled
+	}
+	earliest := len(s.queue) == 0 || message.DeliverAt.Before(s.queue[0].DeliverAt)
+	heap.Push(&s.queue, message)
+	if !earliest {
+		return nil
+	}
+	wait := time.Until(message.DeliverAt)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(wait, s.run)
+	} else {
+		s.timer.Reset(wait)
+	}
+	return nil
+}
+
+// run publishes due messages and rearms the timer for the next one
+func (s *scheduler) run() {
+	for {
+		s.mu.Lock()
+		if s.closed || len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		if wait := time.Until(s.queue[0].DeliverAt); wait > 0 {
+			s.timer.Reset(wait)
+			s.mu.Unlock()
+			return
+		}
+		message, ok := heap.Pop(&s.queue).(Data)
+		s.mu.Unlock()
+
+		if ok {
+			s.publish(message)
+		}
+	}
+}
+
+// close drops pending messages and stops the timer
+func (s *scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.queue = nil
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// delayQueue implements heap.Interface
+type delayQueue []Data
+
+func (q delayQueue) Len() int           { return len(q) }
+func (q delayQueue) Less(i, j int) bool { return q[i].DeliverAt.Before(q[j].DeliverAt) }
+func (q delayQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x any) {
+	if message, ok := x.(Data); ok {
+		*q = append(*q, message)
+	}
+}
+
+func (q *delayQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = Data{}
+	*q = old[:n-1]
+	return x
+}
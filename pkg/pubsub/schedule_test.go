@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDelayedMessagesDeliveredInOrder(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	defer func() {
+		if err := ps.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ch, err := ps.Subscribe("id", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 3
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, m := range []struct {
+		body  string
+		delay time.Duration
+	}{{`2`, 60 * time.Millisecond}, {`1`, 30 * time.Millisecond}, {`0`, 0}} {
+		report, err := ps.PublishData(Data{Topic: "topic", Data: []byte(m.body), DeliverAt: now.Add(m.delay)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Scheduled != (m.delay > 0) {
+			t.Fatalf("unexpected report for delay %s: %+v", m.delay, report)
+		}
+	}
+
+	for _, want := range []string{`0`, `1`, `2`} {
+		select {
+		case got := <-ch:
+			if string(got.Data) != want {
+				t.Fatalf("want %s, got %s", want, got.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %s was not delivered", want)
+		}
+	}
+	if time.Since(now) < 60*time.Millisecond {
+		t.Fatal("delayed message delivered early")
+	}
+}
+
+func TestExpiredMessagesNotDelivered(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.HistorySize = 10 })
+	ch, err := ps.Subscribe("live", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, msg := range []Data{
+		{Topic: "topic", Data: []byte(`"stale"`), ExpiresAt: now.Add(-time.Second)},
+		{Topic: "topic", Data: []byte(`"short"`), ExpiresAt: now.Add(200 * time.Millisecond)},
+		{Topic: "topic", Data: []byte(`"fresh"`), ExpiresAt: now.Add(time.Hour)},
+	} {
+		if _, err := ps.PublishData(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ch) != 2 {
+		t.Fatalf("want 2 live messages, got %d", len(ch))
+	}
+
+	time.Sleep(time.Until(now.Add(200 * time.Millisecond)))
+	replay, err := ps.SubscribeFrom("replay", "topic", 0, func(o *SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-replay; string(got.Data) != `"fresh"` {
+		t.Fatalf("want only the fresh message replayed, got %s", got.Data)
+	}
+}
+
+func TestScheduledLimitAndClose(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.MaxScheduled = 1 })
+	later := time.Now().Add(time.Hour)
+	if _, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`1`), DeliverAt: later}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`2`), DeliverAt: later}); !errors.Is(err, ErrTooManyScheduled) {
+		t.Fatalf("want ErrTooManyScheduled, got %v", err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishData(Data{Topic: "topic", Data: []byte(`3`), DeliverAt: later}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// Storage retains published messages so subscribers can replay them
+type Storage interface {
+	// Append assigns the next sequence number of the message topic,
+	// stores the message and returns it with Seq set.
+	Append(msg Data) (Data, error)
+	// Since returns retained messages of topic with sequence numbers
+	// greater than seq, oldest first.
+	Since(topic string, seq uint64) ([]Data, error)
+	// Last returns the last sequence number assigned to topic, zero
+	// before its first message.
+	Last(topic string) uint64
+	// Close releases resources held by the storage.
+	Close() error
+}
+
+// MemoryStorage keeps a bounded history of every topic in memory
+type MemoryStorage struct {
+	mu        sync.Mutex
+	size      int
+	maxAge    time.Duration
+	histories map[string]*history
+}
+
+// NewMemoryStorage creates storage retaining up to size messages per
+// topic. A positive maxAge also evicts messages older than it.
+func NewMemoryStorage(size int, maxAge time.Duration) *MemoryStorage {
+	return &MemoryStorage{
+		size:      size,
+		maxAge:    maxAge,
+		histories: make(map[string]*history),
+	}
+}
+
+// Append implements Storage interface
+func (m *MemoryStorage) Append(msg Data) (Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histories[msg.Topic]
+	if !ok {
+		h = newHistory(m.size)
+		m.histories[msg.Topic] = h
+	}
+	now := time.Now()
+	h.evict(now, m.maxAge)
+	return h.append(msg, now), nil
+}
+
+// Since implements Storage interface
+func (m *MemoryStorage) Since(topic string, seq uint64) ([]Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histories[topic]
+	if !ok {
+		return nil, nil
+	}
+	h.evict(time.Now(), m.maxAge)
+	return h.since(seq), nil
+}
+
+// Last implements Storage interface
+func (m *MemoryStorage) Last(topic string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if h, ok := m.histories[topic]; ok {
+		return h.seq
+	}
+	return 0
+}
+
+// Close implements Storage interface
+func (m *MemoryStorage) Close() error {
+	return nil
+}
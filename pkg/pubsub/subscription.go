@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// Subscription is a handle of a subscription created with
+// SubscribeContext. It is closed when its context is done.
+type Subscription struct {
+	ps   *PubSub
+	sub  *subscription
+	stop func() bool
+}
+
+// SubscribeContext works like Subscribe but returns a handle and removes
+// the subscription once ctx is done. The handle owns the subscription,
+// so an existing subscription of id to topic fails with ErrSubscribed.
+func (ps *PubSub) SubscribeContext(ctx context.Context, id, topic string, opts ...func(*SubscribeOptions)) (*Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sub, joined, err := ps.subscribePattern(id, topic, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !joined {
+		return nil, fmt.Errorf("%w: %s to %q", ErrSubscribed, id, topic)
+	}
+
+	s := &Subscription{ps: ps, sub: sub}
+	s.stop = context.AfterFunc(ctx, s.close)
+	return s, nil
+}
+
+// C returns the channel messages are delivered to. It is closed with
+// the subscription.
+func (s *Subscription) C() DataChannel {
+	return s.sub.ch
+}
+
+// All returns an iterator over delivered messages. It stops when the
+// subscription is closed, breaking out of the loop keeps it open.
+func (s *Subscription) All() iter.Seq[Data] {
+	return func(yield func(Data) bool) {
+		for msg := range s.sub.ch {
+			if !yield(msg) {
+				return
+			}
+		}
+	}
+}
+
+// Stats returns delivery counters of the subscription
+func (s *Subscription) Stats() SubscriptionStats {
+	return s.sub.stats()
+}
+
+// Close removes the subscription, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.stop()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.ps.remove(s.sub)
+}
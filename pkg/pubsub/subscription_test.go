@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscribeContextIterates(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	sub, err := ps.SubscribeContext(context.Background(), "id", "topic", func(o *SubscribeOptions) {
+		o.BufferSize = 3
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`1`, `2`, `3`} {
+		if _, err := ps.Publish("source", "topic", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for msg := range sub.All() {
+		got = append(got, string(msg.Data))
+		if len(got) == 2 {
+			break
+		}
+	}
+	if len(got) != 2 || got[0] != `1` || got[1] != `2` {
+		t.Fatalf("unexpected messages: %v", got)
+	}
+	if stats := sub.Stats(); stats.Delivered != 3 || stats.Queued != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	sub.Close()
+	sub.Close()
+	for msg := range sub.All() {
+		if string(msg.Data) != `3` {
+			t.Fatalf("unexpected message: %s", msg.Data)
+		}
+	}
+	if _, ok := ps.Stats("id", "topic"); ok {
+		t.Fatal("subscription should be removed")
+	}
+}
+
+func TestSubscribeContextCancel(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := ps.SubscribeContext(ctx, "id", "topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-sub.C():
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed on cancel")
+	}
+	if _, ok := ps.Stats("id", "topic"); ok {
+		t.Fatal("subscription should be removed")
+	}
+
+	if _, err := ps.SubscribeContext(ctx, "id", "topic"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestSubscribeContextExisting(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	if _, err := ps.Subscribe("id", "topic"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := ps.SubscribeContext(ctx, "id", "topic"); !errors.Is(err, ErrSubscribed) {
+		t.Fatalf("want ErrSubscribed, got %v", err)
+	}
+	cancel()
+	if _, ok := ps.Stats("id", "topic"); !ok {
+		t.Fatal("subscription of another caller should be kept")
+	}
+}
@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// System events are published to SystemPrefix followed by the event
+// name, e.g. $sys.subscriber.joined, all events are matched by $sys.>
+// Events of a single topic are selected with a filter such as
+// data.topic == "orders".
+const (
+	SystemPrefix = reservedPrefix + "sys" + separator
+
+	EventTopicCreated     = "topic.created"
+	EventTopicDeleted     = "topic.deleted"
+	EventSubscriberJoined = "subscriber.joined"
+	EventSubscriberLeft   = "subscriber.left"
+	EventLimitRejected    = "limit.rejected"
+)
+
+// SystemEvent is the JSON payload of system events
+type SystemEvent struct {
+	Event      string    `json:"event"`
+	Topic      string    `json:"topic"`
+	Subscriber string    `json:"subscriber,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Transport  string    `json:"transport,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// emit publishes a system event when PubSub.SystemEvents is enabled. It
+// must be called without holding subscription locks.
+func (ps *PubSub) emit(event SystemEvent) {
+	if !ps.SystemEvents {
+		return
+	}
+	event.Time = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		ps.Log.Printf("Error encoding system event %q: %v", event.Event, err)
+		return
+	}
+	// events bypass interceptors and limits so they can't be rejected
+	// into another event, an event failing to store is lost
+	msg := Data{
+		Data:        data,
+		ContentType: "application/json",
+		Source:      strings.TrimSuffix(SystemPrefix, separator),
+		Topic:       SystemPrefix + event.Event,
+		ID:          NewID(),
+		Time:        event.Time,
+		Node:        ps.Node,
+	}
+	if _, err := ps.dispatch(msg); err != nil {
+		ps.Log.Printf("Error publishing system event to %q: %v", msg.Topic, err)
+	}
+}
+
+// emitSubscribe publishes the events of a subscription attempt.
+// Subscriptions of reserved topics emit no events, so a subscriber never
+// receives an event about itself and short lived reply subscriptions
+// stay quiet.
+func (ps *PubSub) emitSubscribe(event SystemEvent, created, joined bool, err error) {
+	if strings.HasPrefix(event.Topic, reservedPrefix) {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrTooManyTopics), errors.Is(err, ErrTooManySubscribers), errors.Is(err, ErrTooManySubscriptions):
+		event.Event = EventLimitRejected
+		event.Error = err.Error()
+		ps.emit(event)
+	case err == nil:
+		if created {
+			ps.emit(SystemEvent{Event: EventTopicCreated, Topic: event.Topic})
+		}
+		if joined {
+			event.Event = EventSubscriberJoined
+			ps.emit(event)
+		}
+	}
+}
+
+// emitUnsubscribe publishes the events of a removed subscription
+func (ps *PubSub) emitUnsubscribe(sub *subscription, deleted bool) {
+	if sub.quiet || strings.HasPrefix(sub.topic, reservedPrefix) {
+		return
+	}
+	ps.emit(SystemEvent{Event: EventSubscriberLeft, Topic: sub.topic, Subscriber: sub.id, Group: sub.group, Transport: sub.transport})
+	if deleted {
+		ps.emit(SystemEvent{Event: EventTopicDeleted, Topic: sub.topic})
+	}
+}
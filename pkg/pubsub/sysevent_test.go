@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSystemEvents(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) {
+		ps.SystemEvents = true
+		ps.MaxSubscribers = 1
+	})
+	events := make(map[string]DataChannel)
+	for _, event := range []string{EventTopicCreated, EventTopicDeleted, EventSubscriberJoined, EventSubscriberLeft, EventLimitRejected} {
+		ch, err := ps.Subscribe("monitor", SystemPrefix+event, func(o *SubscribeOptions) {
+			o.BufferSize = 8
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events[event] = ch
+	}
+	all, err := ps.Subscribe("all", SystemPrefix+">", func(o *SubscribeOptions) {
+		o.BufferSize = 8
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(want SystemEvent) {
+		t.Helper()
+		var msg Data
+		select {
+		case msg = <-events[want.Event]:
+		default:
+			t.Fatalf("missing %s event", want.Event)
+		}
+		if msg.Topic != SystemPrefix+want.Event {
+			t.Fatalf("want event %s, got topic %s", want.Event, msg.Topic)
+		}
+		var got SystemEvent
+		if err := json.Unmarshal(msg.Data, &got); err != nil {
+			t.Fatal(err)
+		}
+		want.Time = got.Time
+		if got.Time.IsZero() || got != want {
+			t.Fatalf("want %+v, got %+v", want, got)
+		}
+	}
+
+	if _, err := ps.Subscribe("alice", "orders", func(o *SubscribeOptions) { o.Transport = "http" }); err != nil {
+		t.Fatal(err)
+	}
+	next(SystemEvent{Event: EventTopicCreated, Topic: "orders"})
+	next(SystemEvent{Event: EventSubscriberJoined, Topic: "orders", Subscriber: "alice", Transport: "http"})
+
+	// subscribing again is not a new subscriber
+	if _, err := ps.Subscribe("alice", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe("bob", "orders", func(o *SubscribeOptions) { o.Transport = "tcp" }); err == nil {
+		t.Fatal("subscriber limit should reject bob")
+	}
+	next(SystemEvent{Event: EventLimitRejected, Topic: "orders", Subscriber: "bob", Transport: "tcp", Error: ErrTooManySubscribers.Error()})
+
+	ps.Unsubscribe("alice", "orders")
+	next(SystemEvent{Event: EventSubscriberLeft, Topic: "orders", Subscriber: "alice", Transport: "http"})
+	next(SystemEvent{Event: EventTopicDeleted, Topic: "orders"})
+
+	if len(all) != 5 {
+		t.Fatalf("want 5 events matched by %s>, got %d", SystemPrefix, len(all))
+	}
+	for event, ch := range events {
+		if len(ch) != 0 {
+			t.Fatalf("unexpected %s events: %d", event, len(ch))
+		}
+	}
+}
+
+func TestSystemEventsDisabled(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	events, err := ps.Subscribe("monitor", SystemPrefix+EventSubscriberJoined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe("alice", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatal("system events should be disabled by default")
+	}
+}
+
+func TestSystemEventSubscriberPublishes(t *testing.T) {
+	t.Parallel()
+
+	ps := New(func(ps *PubSub) { ps.SystemEvents = true })
+	joined, err := ps.Subscribe("presence", SystemPrefix+EventSubscriberJoined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keeps presence as retained messages while subscriptions wait for
+	// the retained lock
+	go func() {
+		for msg := range joined {
+			var event SystemEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				continue
+			}
+			_, _ = ps.PublishData(Data{Topic: "presence." + event.Subscriber, Data: msg.Data, Retain: true})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if _, err := ps.Subscribe("user"+strconv.Itoa(i), "room", func(o *SubscribeOptions) {
+				o.BufferSize = 1
+			}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriptions blocked on the system event subscriber")
+	}
+}
@@ -0,0 +1,209 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Topics are made of tokens separated by dots, e.g. orders.eu.created.
+// Patterns may use * to match exactly one token and a trailing > to match
+// one or more remaining tokens, e.g. orders.*.created or orders.>.
+// Topics starting with $ are reserved, e.g. for dead letters, and are not
+// matched by a wildcard in the first token.
+const (
+	separator      = "."
+	anyToken       = "*"
+	restToken      = ">"
+	reservedPrefix = "$"
+)
+
+// ErrInvalidTopic is returned for malformed topics and patterns
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// ErrReservedTopic is returned by ValidateClientTopic for reserved topics
+var ErrReservedTopic = fmt.Errorf("%w: reserved", ErrInvalidTopic)
+
+// ValidateTopic checks that topic can be published to
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return ErrInvalidTopic
+	}
+	for _, token := range strings.Split(topic, separator) {
+		if token == "" || token == anyToken || token == restToken {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// ValidateClientTopic checks that clients of a transport can publish to
+// topic. Reserved topics are published by PubSub itself, only reply topics
+// take messages from clients.
+func ValidateClientTopic(topic string) error {
+	if err := ValidateTopic(topic); err != nil {
+		return err
+	}
+	if strings.HasPrefix(topic, reservedPrefix) && !isReply(topic) {
+		return ErrReservedTopic
+	}
+	return nil
+}
+
+// ValidatePattern checks that pattern can be subscribed to
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return ErrInvalidTopic
+	}
+	tokens := strings.Split(pattern, separator)
+	for i, token := range tokens {
+		if token == "" || (token == restToken && i != len(tokens)-1) {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// IsPattern reports whether topic contains wildcard tokens
+func IsPattern(topic string) bool {
+	for _, token := range strings.Split(topic, separator) {
+		if token == anyToken || token == restToken {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether topic is matched by pattern
+func Match(pattern, topic string) bool {
+	patternTokens := strings.Split(pattern, separator)
+	topicTokens := strings.Split(topic, separator)
+	if isWildcard(patternTokens[0]) && strings.HasPrefix(topic, reservedPrefix) {
+		return false
+	}
+	for i, token := range patternTokens {
+		if token == restToken {
+			return len(topicTokens) > i
+		}
+		if i >= len(topicTokens) {
+			return false
+		}
+		if token != anyToken && token != topicTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(topicTokens)
+}
+
+func isWildcard(token string) bool {
+	return token == anyToken || token == restToken
+}
+
+// node is a level of the topic trie. Subscriptions of a pattern are kept
+// in the node reached by following its tokens from the root. Publish
+// reads the trie without locks: children are a sync.Map and subs is an
+// immutable snapshot replaced on every change. Writers hold
+// PubSub.trieMu to add or prune nodes.
+type node struct {
+	children sync.Map // token -> *node
+	subs     atomic.Pointer[topicSubs]
+}
+
+func newNode() *node {
+	return &node{}
+}
+
+func (n *node) child(token string) (*node, bool) {
+	value, ok := n.children.Load(token)
+	if !ok {
+		return nil, false
+	}
+	child, ok := value.(*node)
+	return child, ok
+}
+
+// empty reports whether n has no children
+func (n *node) empty() bool {
+	empty := true
+	n.children.Range(func(_, _ any) bool {
+		empty = false
+		return false
+	})
+	return empty
+}
+
+// find returns the node of the pattern
+func (n *node) find(tokens []string) (*node, bool) {
+	for _, token := range tokens {
+		child, ok := n.child(token)
+		if !ok {
+			return nil, false
+		}
+		n = child
+	}
+	return n, true
+}
+
+// insert returns the node of the pattern creating missing levels
+func (n *node) insert(tokens []string) *node {
+	for _, token := range tokens {
+		child, ok := n.child(token)
+		if !ok {
+			child = newNode()
+			n.children.Store(token, child)
+		}
+		n = child
+	}
+	return n
+}
+
+// prune removes empty nodes along the pattern
+func (n *node) prune(tokens []string) {
+	if len(tokens) == 0 {
+		return
+	}
+	child, ok := n.child(tokens[0])
+	if !ok {
+		return
+	}
+	child.prune(tokens[1:])
+	if child.subs.Load() == nil && child.empty() {
+		n.children.Delete(tokens[0])
+	}
+}
+
+// matchTopic appends subscriptions of all patterns matching topic tokens,
+// n is the root of the trie
+func (n *node) matchTopic(tokens []string, out []*topicSubs) []*topicSubs {
+	if !strings.HasPrefix(tokens[0], reservedPrefix) {
+		return n.match(tokens, out)
+	}
+	if child, ok := n.child(tokens[0]); ok {
+		return child.match(tokens[1:], out)
+	}
+	return out
+}
+
+// match appends subscriptions of all patterns matching topic tokens
+func (n *node) match(tokens []string, out []*topicSubs) []*topicSubs {
+	if len(tokens) == 0 {
+		if subs := n.subs.Load(); subs != nil {
+			out = append(out, subs)
+		}
+		return out
+	}
+	if child, ok := n.child(restToken); ok {
+		if subs := child.subs.Load(); subs != nil {
+			out = append(out, subs)
+		}
+	}
+	if child, ok := n.child(tokens[0]); ok {
+		out = child.match(tokens[1:], out)
+	}
+	if child, ok := n.child(anyToken); ok {
+		out = child.match(tokens[1:], out)
+	}
+	return out
+}
@@ -0,0 +1,181 @@
+package pubsub
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestValidateTopic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		topic   string
+		wantErr bool
+	}{
+		{topic: "topic"},
+		{topic: "orders.eu.created"},
+		{topic: "", wantErr: true},
+		{topic: "orders..created", wantErr: true},
+		{topic: "orders.", wantErr: true},
+		{topic: "orders.*.created", wantErr: true},
+		{topic: "orders.>", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			err := ValidateTopic(tt.topic)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("want error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateClientTopic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		topic   string
+		wantErr error
+	}{
+		{topic: "orders.eu"},
+		{topic: ReplyTopic()},
+		{topic: "orders.*", wantErr: ErrInvalidTopic},
+		{topic: DeadLetterPrefix + "orders", wantErr: ErrReservedTopic},
+		{topic: SystemPrefix + "subscribed", wantErr: ErrReservedTopic},
+		{topic: "$other", wantErr: ErrReservedTopic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			err := ValidateClientTopic(tt.topic)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "topic"},
+		{pattern: "orders.*.created"},
+		{pattern: "orders.>"},
+		{pattern: ">"},
+		{pattern: "", wantErr: true},
+		{pattern: "orders..created", wantErr: true},
+		{pattern: "orders.>.created", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := ValidatePattern(tt.pattern)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("want error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "orders", topic: "orders", want: true},
+		{pattern: "orders", topic: "orders.eu"},
+		{pattern: "orders.*.created", topic: "orders.eu.created", want: true},
+		{pattern: "orders.*.created", topic: "orders.eu.deleted"},
+		{pattern: "orders.*.created", topic: "orders.created"},
+		{pattern: "orders.>", topic: "orders.eu", want: true},
+		{pattern: "orders.>", topic: "orders.eu.created", want: true},
+		{pattern: "orders.>", topic: "orders"},
+		{pattern: "*", topic: "orders", want: true},
+		{pattern: "*", topic: "orders.eu"},
+		{pattern: ">", topic: "$dlq.orders"},
+		{pattern: "*.orders", topic: "$dlq.orders"},
+		{pattern: "$dlq.orders.>", topic: "$dlq.orders.eu", want: true},
+		{pattern: "$dlq.>", topic: "$dlq.orders.eu", want: true},
+		{pattern: "$dlq.*", topic: "$dlq.orders", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.topic); got != tt.want {
+				t.Fatalf("want %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPublishMatchesPatterns(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	patterns := []string{"orders.eu.created", "orders.*.created", "orders.>", "orders.us.*", "*.eu.created", "users.>"}
+	for _, pattern := range patterns {
+		if _, err := ps.Subscribe(pattern, pattern, func(o *SubscribeOptions) {
+			o.BufferSize = 1
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ps.Publish("source", "orders.eu.created", []byte(`{"a":1}`))
+
+	var got []string
+	for _, pattern := range patterns {
+		stats, ok := ps.Stats(pattern, pattern)
+		if !ok {
+			t.Fatalf("missing subscription %s", pattern)
+		}
+		if stats.Queued == 1 {
+			got = append(got, pattern)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"*.eu.created", "orders.*.created", "orders.>", "orders.eu.created"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSubscribeInvalidPattern(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	if _, err := ps.Subscribe("user", "orders.>.created"); !errors.Is(err, ErrInvalidTopic) {
+		t.Fatalf("want ErrInvalidTopic, got %v", err)
+	}
+}
+
+func TestUnsubscribePrunesTrie(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	for _, pattern := range []string{"orders.*.created", "orders.eu.>"} {
+		if _, err := ps.Subscribe("user", pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps.Unsubscribe("user", "orders.*.created")
+	ps.Unsubscribe("user", "orders.eu.>")
+
+	if !ps.root.empty() {
+		t.Fatal("expected empty trie")
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// Typed publishes and subscribes values of T encoded with a Codec
+type Typed[T any] struct {
+	PubSub *PubSub
+	Codec  Codec[T]
+}
+
+// NewTyped creates a typed layer over ps
+func NewTyped[T any](ps *PubSub, codec Codec[T]) *Typed[T] {
+	return &Typed[T]{PubSub: ps, Codec: codec}
+}
+
+// Message is a delivered message with its decoded value
+type Message[T any] struct {
+	Data
+	Value T
+}
+
+// Publish encodes v and publishes it on topic
+func (t *Typed[T]) Publish(source, topic string, v T) (Report, error) {
+	return t.PublishData(Data{Source: source, Topic: topic}, v)
+}
+
+// PublishData encodes v as the payload of message and publishes it, see
+// PubSub.PublishData
+func (t *Typed[T]) PublishData(message Data, v T) (Report, error) {
+	body, err := t.Codec.Encode(v)
+	if err != nil {
+		return Report{Message: message}, err
+	}
+	if err := message.SetPayload(t.Codec.ContentType(), body); err != nil {
+		return Report{Message: message}, err
+	}
+	return t.PubSub.PublishData(message)
+}
+
+// Subscribe subscribes to topic until ctx is done, see
+// PubSub.SubscribeContext
+func (t *Typed[T]) Subscribe(ctx context.Context, id, topic string, opts ...func(*SubscribeOptions)) (*TypedSubscription[T], error) {
+	sub, err := t.PubSub.SubscribeContext(ctx, id, topic, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedSubscription[T]{Subscription: sub, codec: t.Codec}, nil
+}
+
+// TypedSubscription is a subscription decoding delivered messages
+type TypedSubscription[T any] struct {
+	*Subscription
+	codec Codec[T]
+}
+
+// All returns an iterator over delivered messages. Messages which fail
+// to decode are yielded with the error and iteration continues.
+func (s *TypedSubscription[T]) All() iter.Seq2[Message[T], error] {
+	return func(yield func(Message[T], error) bool) {
+		for msg := range s.Subscription.All() {
+			value, err := s.decode(msg)
+			if !yield(Message[T]{Data: msg, Value: value}, err) {
+				return
+			}
+		}
+	}
+}
+
+func (s *TypedSubscription[T]) decode(msg Data) (T, error) {
+	var value T
+	body, err := msg.Payload()
+	if err == nil {
+		value, err = s.codec.Decode(body)
+	}
+	if err != nil {
+		return value, fmt.Errorf("decode message %s: %w", msg.ID, err)
+	}
+	return value, nil
+}
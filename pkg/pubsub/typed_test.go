@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+)
+
+type order struct {
+	ID    string
+	Total int
+}
+
+func TestTypedReportsDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	orders := NewTyped[order](ps, JSONCodec[order]{})
+	sub, err := orders.Subscribe(context.Background(), "id", "orders", func(o *SubscribeOptions) {
+		o.BufferSize = 2
+		o.Overflow = DropNewest
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if _, err := ps.Publish("source", "orders", []byte("not an order")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orders.Publish("source", "orders", order{ID: "a", Total: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs int
+	for msg, err := range sub.All() {
+		if err != nil {
+			errs++
+			continue
+		}
+		if msg.Value != (order{ID: "a", Total: 10}) || msg.ContentType != "application/json" {
+			t.Fatalf("unexpected message: %+v", msg)
+		}
+		break
+	}
+	if errs != 1 {
+		t.Fatalf("want 1 decode error, got %d", errs)
+	}
+}
+
+func TestTypedCodecs(t *testing.T) {
+	t.Parallel()
+
+	ps := New()
+	gobOrders := NewTyped[order](ps, GobCodec[order]{})
+	gobSub, err := gobOrders.Subscribe(context.Background(), "gob", "gob", func(o *SubscribeOptions) { o.Overflow = DropNewest })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gobSub.Close()
+	raw := NewTyped[[]byte](ps, RawCodec{Type: "text/csv"})
+	rawSub, err := raw.Subscribe(context.Background(), "raw", "raw", func(o *SubscribeOptions) { o.Overflow = DropNewest })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawSub.Close()
+
+	if _, err := gobOrders.Publish("source", "gob", order{ID: "b", Total: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Publish("source", "raw", []byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for msg, err := range gobSub.All() {
+		if err != nil || msg.Value != (order{ID: "b", Total: 2}) || msg.Encoding != EncodingBase64 {
+			t.Fatalf("unexpected gob message: %+v, %v", msg, err)
+		}
+		break
+	}
+	for msg, err := range rawSub.All() {
+		if err != nil || string(msg.Value) != "a,b\n" || msg.ContentType != "text/csv" {
+			t.Fatalf("unexpected raw message: %+v, %v", msg, err)
+		}
+		break
+	}
+}
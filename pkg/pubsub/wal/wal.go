@@ -0,0 +1,505 @@
+// Package wal provides a durable pubsub.Storage backed by an append-only
+// log split into segment files.
+//
+// Every record is framed as
+//
+//	length (4 bytes) | crc32c (4 bytes) | unix nano time (8 bytes) | payload
+//
+// where payload is the JSON encoded message and the checksum covers time
+// and payload. A torn record at the end of the newest segment, left by a
+// crash in the middle of a write, is truncated when the log is opened.
+//
+// Retention removes whole segments, it is applied when the log is opened,
+// whenever a segment rolls over and every RetentionEvery, which also seals
+// an active segment older than MaxAge so quiet logs expire too. The last sequence number of every
+// topic is saved to a sequence file before segments are removed, so
+// sequences keep growing after a restart even when retention removed all
+// records of a topic.
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+const (
+	headerSize    = 16
+	maxRecordSize = 64 << 20
+	segmentExt    = ".wal"
+	sequenceFile  = "sequences.json"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned by Open when a sealed segment fails validation
+var ErrCorrupt = errors.New("corrupt log segment")
+
+// SyncPolicy decides when appended records are flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncInterval flushes the active segment every SyncEvery.
+	SyncInterval SyncPolicy = iota
+	// SyncAlways flushes after every append.
+	SyncAlways
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64
+	last time.Time
+}
+
+type position struct {
+	seq    uint64
+	seg    *segment
+	offset int64
+	size   int64
+}
+
+// Log implements pubsub.Storage on top of segment files in Dir
+type Log struct {
+	Dir string
+	// SegmentSize is the size after which a new segment is started.
+	SegmentSize int64
+	// MaxSize caps the total size of all segments, zero means no limit.
+	MaxSize int64
+	// MaxAge removes segments whose newest record is older than it,
+	// zero means no limit.
+	MaxAge time.Duration
+	// RetentionEvery is how often MaxAge is enforced between rollovers.
+	RetentionEvery time.Duration
+	Sync           SyncPolicy
+	SyncEvery      time.Duration
+	Log            *log.Logger
+
+	mu       sync.Mutex
+	segments []*segment
+	index    map[string][]position
+	seqs     map[string]uint64
+	dirty    bool
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// Open opens the log in dir, creating it when needed, and recovers
+// its index from existing segments
+func Open(dir string, opts ...func(*Log)) (*Log, error) {
+	l := &Log{
+		Dir:            dir,
+		SegmentSize:    64 << 20,
+		Sync:           SyncInterval,
+		SyncEvery:      time.Second,
+		RetentionEvery: time.Minute,
+		Log:            log.New(os.Stdout, "[WAL] ", log.LstdFlags),
+		index:          make(map[string][]position),
+		seqs:           make(map[string]uint64),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if err := os.MkdirAll(l.Dir, 0o750); err != nil {
+		return nil, err
+	}
+	if err := l.recover(); err != nil {
+		return nil, errors.Join(err, l.closeSegments())
+	}
+	if len(l.segments) == 0 {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	l.enforceRetention(time.Now())
+	go l.run()
+	return l, nil
+}
+
+// Append implements pubsub.Storage interface
+func (l *Log) Append(msg pubsub.Data) (pubsub.Data, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	msg.Seq = l.seqs[msg.Topic] + 1
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	if len(payload) > maxRecordSize {
+		return msg, fmt.Errorf("record of %d bytes exceeds %d bytes", len(payload), maxRecordSize)
+	}
+
+	now := time.Now()
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))    // #nosec G115 -- bounded by maxRecordSize
+	binary.BigEndian.PutUint64(record[8:16], uint64(now.UnixNano())) // #nosec G115 -- time after epoch
+	copy(record[headerSize:], payload)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], crcTable))
+
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > l.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return msg, err
+		}
+		active = l.segments[len(l.segments)-1]
+		l.enforceRetention(now)
+	}
+
+	if _, err := active.file.Write(record); err != nil {
+		return msg, err
+	}
+	if l.Sync == SyncAlways {
+		if err := active.file.Sync(); err != nil {
+			return msg, err
+		}
+	} else {
+		l.dirty = true
+	}
+
+	l.index[msg.Topic] = append(l.index[msg.Topic], position{
+		seq:    msg.Seq,
+		seg:    active,
+		offset: active.size,
+		size:   int64(len(record)),
+	})
+	l.seqs[msg.Topic] = msg.Seq
+	active.size += int64(len(record))
+	active.last = now
+	return msg, nil
+}
+
+// Since implements pubsub.Storage interface
+func (l *Log) Since(topic string, seq uint64) ([]pubsub.Data, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	positions := l.index[topic]
+	i := sort.Search(len(positions), func(i int) bool {
+		return positions[i].seq > seq
+	})
+	out := make([]pubsub.Data, 0, len(positions)-i)
+	for _, pos := range positions[i:] {
+		record := make([]byte, pos.size)
+		if _, err := pos.seg.file.ReadAt(record, pos.offset); err != nil {
+			return nil, err
+		}
+		var msg pubsub.Data
+		if err := json.Unmarshal(record[headerSize:], &msg); err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// Last implements pubsub.Storage interface
+func (l *Log) Last(topic string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.seqs[topic]
+}
+
+// Close implements pubsub.Storage interface
+func (l *Log) Close() error {
+	close(l.stop)
+	<-l.stopped
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	if len(l.segments) > 0 && l.Sync != SyncNever {
+		err = l.segments[len(l.segments)-1].file.Sync()
+	}
+	return errors.Join(err, l.closeSegments())
+}
+
+// run syncs the active segment every SyncEvery and enforces MaxAge every
+// RetentionEvery until the log is closed
+func (l *Log) run() {
+	defer close(l.stopped)
+
+	var syncs, expiries <-chan time.Time
+	if l.Sync == SyncInterval && l.SyncEvery > 0 {
+		ticker := time.NewTicker(l.SyncEvery)
+		defer ticker.Stop()
+		syncs = ticker.C
+	}
+	if l.MaxAge > 0 && l.RetentionEvery > 0 {
+		ticker := time.NewTicker(l.RetentionEvery)
+		defer ticker.Stop()
+		expiries = ticker.C
+	}
+	for {
+		select {
+		case <-syncs:
+			l.mu.Lock()
+			if l.dirty {
+				if err := l.segments[len(l.segments)-1].file.Sync(); err != nil {
+					l.Log.Printf("Error syncing segment: %v", err)
+				}
+				l.dirty = false
+			}
+			l.mu.Unlock()
+		case now := <-expiries:
+			l.expire(now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// expire seals the active segment once its newest record is older than
+// MaxAge, so retention removes records of logs no longer written to
+func (l *Log) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && now.Sub(active.last) > l.MaxAge {
+		if err := l.rotate(); err != nil {
+			l.Log.Printf("Error sealing segment: %v", err)
+			return
+		}
+	}
+	l.enforceRetention(now)
+}
+
+func (l *Log) segmentPath(id uint64) string {
+	return filepath.Join(l.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// rotate seals the active segment and starts a new one
+func (l *Log) rotate() error {
+	var id uint64
+	if n := len(l.segments); n > 0 {
+		active := l.segments[n-1]
+		if l.Sync != SyncNever {
+			if err := active.file.Sync(); err != nil {
+				return err
+			}
+		}
+		l.dirty = false
+		id = active.id + 1
+	}
+	// #nosec G304 -- path is built from the configured directory
+	f, err := os.OpenFile(l.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, &segment{id: id, file: f})
+	return nil
+}
+
+// enforceRetention removes the oldest sealed segments exceeding MaxSize
+// or MaxAge together with their index entries
+func (l *Log) enforceRetention(now time.Time) {
+	var total int64
+	for _, seg := range l.segments {
+		total += seg.size
+	}
+	saved := false
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		tooBig := l.MaxSize > 0 && total > l.MaxSize
+		tooOld := l.MaxAge > 0 && now.Sub(oldest.last) > l.MaxAge
+		if !tooBig && !tooOld {
+			return
+		}
+		if !saved {
+			// keep segments rather than lose sequence numbers
+			if err := l.saveSequences(); err != nil {
+				l.Log.Printf("Error saving sequences: %v", err)
+				return
+			}
+			saved = true
+		}
+		l.removeSegment(oldest)
+		total -= oldest.size
+	}
+}
+
+func (l *Log) removeSegment(seg *segment) {
+	l.segments = l.segments[1:]
+	for topic, positions := range l.index {
+		i := 0
+		for i < len(positions) && positions[i].seg == seg {
+			i++
+		}
+		if i == len(positions) {
+			delete(l.index, topic)
+		} else if i > 0 {
+			l.index[topic] = positions[i:]
+		}
+	}
+	if err := seg.file.Close(); err != nil {
+		l.Log.Printf("Error closing segment: %v", err)
+	}
+	if err := os.Remove(l.segmentPath(seg.id)); err != nil {
+		l.Log.Printf("Error removing segment: %v", err)
+	}
+}
+
+// saveSequences atomically replaces the sequence file with the last
+// sequence number of every topic
+func (l *Log) saveSequences() error {
+	data, err := json.Marshal(l.seqs)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.Dir, sequenceFile)
+	// #nosec G304 -- path is built from the configured directory
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadSequences reads the sequence file saved before segments were removed
+func (l *Log) loadSequences() error {
+	// #nosec G304 -- path is built from the configured directory
+	data, err := os.ReadFile(filepath.Join(l.Dir, sequenceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &l.seqs); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrCorrupt, sequenceFile, err)
+	}
+	return nil
+}
+
+func (l *Log) closeSegments() error {
+	var errs []error
+	for _, seg := range l.segments {
+		errs = append(errs, seg.file.Close())
+	}
+	l.segments = nil
+	return errors.Join(errs...)
+}
+
+// recover opens existing segments in order and rebuilds the index
+func (l *Log) recover() error {
+	if err := l.loadSequences(); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		var id uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExt), "%d", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for i, id := range ids {
+		// #nosec G304 -- path is built from the configured directory
+		f, err := os.OpenFile(l.segmentPath(id), os.O_RDWR|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		seg := &segment{id: id, file: f}
+		l.segments = append(l.segments, seg)
+		if err := l.scan(seg, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan indexes records of seg. A damaged tail of the newest segment is
+// truncated, damage anywhere else is reported as ErrCorrupt.
+func (l *Log) scan(seg *segment, newest bool) error {
+	info, err := seg.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	header := make([]byte, headerSize)
+	var offset int64
+	for offset < size {
+		msg, recordSize, err := readRecord(seg.file, offset, header)
+		if err != nil {
+			if !newest {
+				return fmt.Errorf("%w %s at offset %d: %v", ErrCorrupt, l.segmentPath(seg.id), offset, err)
+			}
+			l.Log.Printf("Truncating %s at offset %d: %v", l.segmentPath(seg.id), offset, err)
+			if err := seg.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		l.index[msg.Topic] = append(l.index[msg.Topic], position{
+			seq:    msg.Seq,
+			seg:    seg,
+			offset: offset,
+			size:   recordSize,
+		})
+		if msg.Seq > l.seqs[msg.Topic] {
+			l.seqs[msg.Topic] = msg.Seq
+		}
+		seg.last = time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16]))) // #nosec G115 -- written from UnixNano
+		offset += recordSize
+	}
+	seg.size = offset
+	return nil
+}
+
+func readRecord(r io.ReaderAt, offset int64, header []byte) (pubsub.Data, int64, error) {
+	var msg pubsub.Data
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return msg, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return msg, 0, fmt.Errorf("record size %d exceeds limit", size)
+	}
+	body := make([]byte, headerSize-8+int(size))
+	if _, err := r.ReadAt(body, offset+8); err != nil {
+		return msg, 0, err
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return msg, 0, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(body[8:], &msg); err != nil {
+		return msg, 0, err
+	}
+	return msg, headerSize + int64(size), nil
+}
@@ -0,0 +1,288 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkorkosz/go-hook/pkg/pubsub"
+)
+
+func open(t *testing.T, dir string, opts ...func(*Log)) *Log {
+	t.Helper()
+
+	opts = append([]func(*Log){func(l *Log) {
+		l.Log = log.New(io.Discard, "", 0)
+	}}, opts...)
+	l, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func appendMessages(t *testing.T, l *Log, topic string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if _, err := l.Append(pubsub.Data{Topic: topic, Source: "source", Data: []byte(`{"a":1}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func since(t *testing.T, l *Log, topic string, seq uint64) []pubsub.Data {
+	t.Helper()
+
+	out, err := l.Since(topic, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestAppendAndSince(t *testing.T) {
+	t.Parallel()
+
+	l := open(t, t.TempDir())
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	appendMessages(t, l, "a", 3)
+	appendMessages(t, l, "b", 1)
+
+	got := since(t, l, "a", 1)
+	if len(got) != 2 || got[0].Seq != 2 || got[1].Seq != 3 {
+		t.Fatalf("unexpected messages: %+v", got)
+	}
+	if string(got[0].Data) != `{"a":1}` || got[0].Source != "source" {
+		t.Fatalf("unexpected message: %+v", got[0])
+	}
+	if got := since(t, l, "b", 0); len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("unexpected messages: %+v", got)
+	}
+}
+
+func TestReopenRecoversMessages(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	l := open(t, dir, func(l *Log) {
+		l.Sync = SyncAlways
+	})
+	appendMessages(t, l, "topic", 3)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if got := since(t, l, "topic", 0); len(got) != 3 {
+		t.Fatalf("want 3 messages, got %d", len(got))
+	}
+	msg, err := l.Append(pubsub.Data{Topic: "topic", Data: []byte(`1`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 4 {
+		t.Fatalf("want seq 4, got %d", msg.Seq)
+	}
+}
+
+func TestOpenTruncatesTornWrite(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	l := open(t, dir, func(l *Log) {
+		l.Sync = SyncNever
+	})
+	appendMessages(t, l, "topic", 2)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "00000000000000000000.wal")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if got := since(t, l, "topic", 0); len(got) != 1 {
+		t.Fatalf("want 1 message, got %d", len(got))
+	}
+	appendMessages(t, l, "topic", 1)
+	if got := since(t, l, "topic", 1); len(got) != 1 || got[0].Seq != 2 {
+		t.Fatalf("unexpected messages: %+v", got)
+	}
+}
+
+func TestOpenRejectsCorruptSealedSegment(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	l := open(t, dir, func(l *Log) {
+		l.SegmentSize = 1
+	})
+	appendMessages(t, l, "topic", 2)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "00000000000000000000.wal")
+	data, err := os.ReadFile(path) // #nosec G304 -- test directory
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, func(l *Log) {
+		l.Log = log.New(io.Discard, "", 0)
+	}); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("want ErrCorrupt, got %v", err)
+	}
+}
+
+func TestRetentionRemovesOldestSegments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	l := open(t, dir, func(l *Log) {
+		l.SegmentSize = 1
+		l.MaxSize = 200
+	})
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	appendMessages(t, l, "topic", 10)
+
+	got := since(t, l, "topic", 0)
+	if len(got) == 0 || len(got) >= 10 {
+		t.Fatalf("expected retention to drop some messages, got %d", len(got))
+	}
+	if got[len(got)-1].Seq != 10 {
+		t.Fatalf("want newest seq 10, got %d", got[len(got)-1].Seq)
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != len(got) {
+		t.Fatalf("want %d segments, got %d", len(got), len(segments))
+	}
+}
+
+func TestSequencesSurviveRetention(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	l := open(t, dir, func(l *Log) {
+		l.SegmentSize = 1
+		l.MaxSize = 200
+	})
+	appendMessages(t, l, "old", 20)
+	// retention removes every record of old
+	appendMessages(t, l, "new", 20)
+	if got := since(t, l, "old", 0); len(got) != 0 {
+		t.Fatalf("expected retention to drop old, got %d messages", len(got))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	msg, err := l.Append(pubsub.Data{Topic: "old", Source: "source", Data: []byte(`{"a":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 21 {
+		t.Fatalf("want seq 21 after restart, got %d", msg.Seq)
+	}
+}
+
+func TestRetentionExpiresQuietLog(t *testing.T) {
+	t.Parallel()
+
+	l := open(t, t.TempDir(), func(l *Log) {
+		l.MaxAge = 10 * time.Millisecond
+		l.RetentionEvery = 5 * time.Millisecond
+	})
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	appendMessages(t, l, "topic", 3)
+
+	deadline := time.Now().Add(time.Second)
+	for len(since(t, l, "topic", 0)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected MaxAge to remove records of the active segment")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if last := l.Last("topic"); last != 3 {
+		t.Fatalf("want last seq 3, got %d", last)
+	}
+}
+
+func TestPubSubReplaysAfterRestart(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ps := pubsub.New(func(ps *pubsub.PubSub) {
+		ps.Storage = open(t, dir)
+	})
+	if _, err := ps.Publish("source", "topic", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ps = pubsub.New(func(ps *pubsub.PubSub) {
+		ps.Storage = open(t, dir)
+	})
+	defer func() {
+		if err := ps.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ch, err := ps.SubscribeFrom("user", "topic", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-ch
+	if msg.Seq != 1 || string(msg.Data) != `{"a":1}` {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}